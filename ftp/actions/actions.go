@@ -0,0 +1,183 @@
+// Package actions runs the built in post-upload
+// actions (local commands and HTTP webhooks)
+// selected by per path rules.
+package actions
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/mindflavor/ftpserver2/ftp/session"
+)
+
+// Action is run for the events
+// matching its Rule
+type Action interface {
+	Run(e session.Event) error
+}
+
+// Rule selects the Action run for the files whose path
+// either is inside the Path directory or matches the
+// Path glob pattern (see path.Match).
+type Rule struct {
+	Path   string
+	Action Action
+}
+
+// Matches returns true if p is selected by the rule
+func (r Rule) Matches(p string) bool {
+	pattern := path.Clean(r.Path)
+	if pattern == "/" {
+		return true
+	}
+
+	if ok, _ := path.Match(pattern, p); ok {
+		return true
+	}
+
+	return strings.HasPrefix(p, pattern+"/")
+}
+
+// Rules are checked in order and
+// all the matching actions are run
+type Rules []Rule
+
+// Handler returns a session.EventHandler running the
+// actions of the rules matching the event path.
+// Register it as session.HookAsync: the actions can
+// take a long time.
+func (rs Rules) Handler() session.EventHandler {
+	return func(e session.Event) error {
+		var failed error
+		for _, r := range rs {
+			if !r.Matches(e.Path) {
+				continue
+			}
+
+			log.WithFields(log.Fields{"rule": r.Path, "path": e.Path, "user": e.Username}).Debug("actions::Rules::Handler running action")
+
+			if err := r.Action.Run(e); err != nil {
+				log.WithFields(log.Fields{"rule": r.Path, "path": e.Path, "user": e.Username, "err": err}).Warn("actions::Rules::Handler action failed")
+				failed = err
+			}
+		}
+		return failed
+	}
+}
+
+// Parse reads the rules configuration. Each line is either:
+//
+//	command <path> [timeout=<duration>] <executable> [<arg>...]
+//	webhook <path> [timeout=<duration>] [retries=<n>] [secret=<key>] <url>
+//
+// where <path> is a directory or a glob pattern. Empty lines
+// and lines starting with # are ignored. root, if not empty,
+// is the local file system root passed to the commands.
+func Parse(r io.Reader, root string) (Rules, error) {
+	var rules Rules
+
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		toks := strings.Fields(scanner.Text())
+		if len(toks) == 0 || strings.HasPrefix(toks[0], "#") {
+			continue
+		}
+
+		if len(toks) < 3 {
+			return nil, fmt.Errorf("line %d: action, path and target expected", lineNo)
+		}
+
+		kind, p := strings.ToLower(toks[0]), toks[1]
+		options, args := splitOptions(toks[2:])
+		if len(args) == 0 {
+			return nil, fmt.Errorf("line %d: target expected", lineNo)
+		}
+
+		var action Action
+		switch kind {
+		case "command":
+			c := &Command{Name: args[0], Args: args[1:], Root: root, Timeout: DefaultCommandTimeout}
+			for k, v := range options {
+				if k != "timeout" {
+					return nil, fmt.Errorf("line %d: unknown command option %s", lineNo, k)
+				}
+				d, err := time.ParseDuration(v)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %s", lineNo, err)
+				}
+				c.Timeout = d
+			}
+			action = c
+		case "webhook":
+			if len(args) != 1 {
+				return nil, fmt.Errorf("line %d: a single webhook URL expected", lineNo)
+			}
+			w := NewWebhook(args[0], nil)
+			for k, v := range options {
+				switch k {
+				case "timeout":
+					d, err := time.ParseDuration(v)
+					if err != nil {
+						return nil, fmt.Errorf("line %d: %s", lineNo, err)
+					}
+					w.Client.Timeout = d
+				case "retries":
+					n, err := strconv.Atoi(v)
+					if err != nil {
+						return nil, fmt.Errorf("line %d: %s", lineNo, err)
+					}
+					w.Retries = n
+				case "secret":
+					w.Secret = []byte(v)
+				default:
+					return nil, fmt.Errorf("line %d: unknown webhook option %s", lineNo, k)
+				}
+			}
+			action = w
+		default:
+			return nil, fmt.Errorf("line %d: unknown action %s", lineNo, toks[0])
+		}
+
+		rules = append(rules, Rule{Path: p, Action: action})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+// LoadFile parses the specified rules file
+func LoadFile(path string, root string) (Rules, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Parse(f, root)
+}
+
+// splitOptions separates the leading
+// key=value tokens from the others
+func splitOptions(toks []string) (map[string]string, []string) {
+	options := make(map[string]string)
+	for i, t := range toks {
+		kv := strings.SplitN(t, "=", 2)
+		if len(kv) != 2 || strings.Contains(kv[0], "/") {
+			return options, toks[i:]
+		}
+		options[strings.ToLower(kv[0])] = kv[1]
+	}
+	return options, nil
+}
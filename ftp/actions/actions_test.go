@@ -0,0 +1,118 @@
+package actions
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mindflavor/ftpserver2/ftp/session"
+	"github.com/stretchr/testify/assert"
+)
+
+var upload = session.Event{
+	Type:       session.EventUploadComplete,
+	Time:       time.Date(2016, time.March, 5, 9, 4, 2, 0, time.UTC),
+	Username:   "frank",
+	RemoteAddr: "10.0.0.1:4000",
+	Path:       "/incoming/report.csv",
+	Size:       1024,
+}
+
+func TestRuleMatches(t *testing.T) {
+	assert.True(t, Rule{Path: "/incoming"}.Matches("/incoming/report.csv"))
+	assert.True(t, Rule{Path: "/incoming/"}.Matches("/incoming/sub/report.csv"))
+	assert.False(t, Rule{Path: "/incoming"}.Matches("/incomingx/report.csv"))
+	assert.True(t, Rule{Path: "/incoming/*.csv"}.Matches("/incoming/report.csv"))
+	assert.False(t, Rule{Path: "/incoming/*.csv"}.Matches("/incoming/report.txt"))
+	assert.True(t, Rule{Path: "/"}.Matches("/report.txt"))
+}
+
+func TestParse(t *testing.T) {
+	rules, err := Parse(strings.NewReader(`
+# comment
+command /incoming/*.csv timeout=30s /usr/local/bin/import --fast
+webhook /partners retries=5 secret=s3cr3t https://example.com/hook?a=b
+`), "/srv/ftp")
+	assert.NoError(t, err)
+	assert.Len(t, rules, 2)
+
+	c := rules[0].Action.(*Command)
+	assert.Equal(t, "/incoming/*.csv", rules[0].Path)
+	assert.Equal(t, "/usr/local/bin/import", c.Name)
+	assert.Equal(t, []string{"--fast"}, c.Args)
+	assert.Equal(t, 30*time.Second, c.Timeout)
+	assert.Equal(t, "/srv/ftp", c.Root)
+
+	w := rules[1].Action.(*Webhook)
+	assert.Equal(t, "https://example.com/hook?a=b", w.URL)
+	assert.Equal(t, 5, w.Retries)
+	assert.Equal(t, []byte("s3cr3t"), w.Secret)
+
+	_, err = Parse(strings.NewReader("email /incoming admin@example.com\n"), "")
+	assert.Error(t, err)
+	_, err = Parse(strings.NewReader("webhook /incoming colour=blue https://example.com\n"), "")
+	assert.Error(t, err)
+}
+
+func TestWebhook(t *testing.T) {
+	calls := 0
+	var body []byte
+	var signature string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ = ioutil.ReadAll(r.Body)
+		signature = r.Header.Get(SignatureHeader)
+	}))
+	defer srv.Close()
+
+	w := NewWebhook(srv.URL, []byte("key"))
+	w.RetryDelay = time.Millisecond
+	assert.NoError(t, w.Run(upload))
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, "sha256="+Sign([]byte("key"), body), signature)
+
+	var p Payload
+	assert.NoError(t, json.Unmarshal(body, &p))
+	assert.Equal(t, "UploadComplete", p.Event)
+	assert.Equal(t, "/incoming/report.csv", p.Path)
+	assert.Equal(t, "frank", p.User)
+	assert.Equal(t, int64(1024), p.Size)
+
+	w.Retries = 0
+	calls = 0
+	assert.Error(t, w.Run(upload))
+	assert.Equal(t, 1, calls)
+}
+
+func TestCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "actions")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, "out")
+	c := &Command{
+		Name:    "/bin/sh",
+		Args:    []string{"-c", `echo "$FTP_EVENT $FTP_USER $FTP_PATH $FTP_LOCAL_PATH $FTP_SIZE" > ` + out},
+		Root:    "/srv/ftp",
+		Timeout: 5 * time.Second,
+	}
+	assert.NoError(t, Rules{{Path: "/incoming", Action: c}}.Handler()(upload))
+
+	b, err := ioutil.ReadFile(out)
+	assert.NoError(t, err)
+	assert.Equal(t, "UploadComplete frank /incoming/report.csv /srv/ftp/incoming/report.csv 1024\n", string(b))
+
+	c = &Command{Name: "/bin/sh", Args: []string{"-c", "sleep 5"}, Timeout: 50 * time.Millisecond}
+	assert.Error(t, c.Run(upload))
+}
@@ -0,0 +1,72 @@
+package actions
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/mindflavor/ftpserver2/ftp/session"
+)
+
+// DefaultCommandTimeout is the Command timeout
+// used by Parse if none is specified
+const DefaultCommandTimeout = time.Minute
+
+// Command runs a local executable. The event is passed
+// in the environment: FTP_EVENT, FTP_PATH (the FTP path),
+// FTP_LOCAL_PATH (only if Root is set), FTP_USER,
+// FTP_REMOTE_ADDR, FTP_SIZE and FTP_TLS. The process is
+// killed after Timeout (0 means no timeout).
+type Command struct {
+	Name    string
+	Args    []string
+	Root    string
+	Timeout time.Duration
+}
+
+// Run implements Action
+func (c *Command) Run(e session.Event) error {
+	ctx := context.Background()
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, c.Name, c.Args...)
+	cmd.Env = append(os.Environ(), c.env(e)...)
+	// do not wait for the children still holding the output
+	cmd.WaitDelay = time.Second
+
+	out, err := cmd.CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("%s timed out after %s", c.Name, c.Timeout)
+	}
+
+	log.WithFields(log.Fields{"command": c.Name, "path": e.Path, "output": string(out), "err": err}).Info("actions::Command::Run command completed")
+	return err
+}
+
+// env returns the environment
+// variables describing e
+func (c *Command) env(e session.Event) []string {
+	env := []string{
+		"FTP_EVENT=" + e.Type.String(),
+		"FTP_PATH=" + e.Path,
+		"FTP_USER=" + e.Username,
+		"FTP_REMOTE_ADDR=" + e.RemoteAddr,
+		"FTP_SIZE=" + strconv.FormatInt(e.Size, 10),
+		"FTP_TLS=" + strconv.FormatBool(e.TLS),
+	}
+
+	if c.Root != "" {
+		env = append(env, "FTP_LOCAL_PATH="+filepath.Join(c.Root, filepath.FromSlash(e.Path)))
+	}
+
+	return env
+}
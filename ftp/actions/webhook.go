@@ -0,0 +1,133 @@
+package actions
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/mindflavor/ftpserver2/ftp/session"
+)
+
+// Webhook defaults
+const (
+	DefaultWebhookTimeout = 10 * time.Second
+	DefaultWebhookRetries = 3
+	DefaultRetryDelay     = time.Second
+)
+
+// SignatureHeader carries the hex encoded HMAC-SHA256
+// of the body (sha256=<digest>) if the Webhook has a Secret
+const SignatureHeader = "X-FTP-Signature"
+
+// Webhook POSTs the event as JSON to URL. Failed requests
+// (errors and non 2xx replies) are retried up to Retries
+// times waiting RetryDelay, doubled at each attempt.
+type Webhook struct {
+	URL        string
+	Secret     []byte
+	Retries    int
+	RetryDelay time.Duration
+	Client     *http.Client
+}
+
+// Payload is the JSON body sent by a Webhook
+type Payload struct {
+	Event      string    `json:"event"`
+	Time       time.Time `json:"time"`
+	Path       string    `json:"path"`
+	Size       int64     `json:"size"`
+	User       string    `json:"user"`
+	RemoteAddr string    `json:"remote_addr"`
+	TLS        bool      `json:"tls"`
+}
+
+// NewWebhook creates a Webhook with the
+// default timeout and retries
+func NewWebhook(url string, secret []byte) *Webhook {
+	return &Webhook{
+		URL:        url,
+		Secret:     secret,
+		Retries:    DefaultWebhookRetries,
+		RetryDelay: DefaultRetryDelay,
+		Client:     &http.Client{Timeout: DefaultWebhookTimeout},
+	}
+}
+
+// Run implements Action
+func (w *Webhook) Run(e session.Event) error {
+	body, err := json.Marshal(Payload{
+		Event:      e.Type.String(),
+		Time:       e.Time,
+		Path:       e.Path,
+		Size:       e.Size,
+		User:       e.Username,
+		RemoteAddr: e.RemoteAddr,
+		TLS:        e.TLS,
+	})
+	if err != nil {
+		return err
+	}
+
+	delay := w.RetryDelay
+	for attempt := 0; ; attempt++ {
+		err = w.post(body)
+		if err == nil {
+			return nil
+		}
+
+		log.WithFields(log.Fields{"url": w.URL, "path": e.Path, "attempt": attempt + 1, "err": err}).Warn("actions::Webhook::Run request failed")
+
+		if attempt >= w.Retries {
+			return err
+		}
+
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// post sends a single request
+func (w *Webhook) post(body []byte) error {
+	req, err := http.NewRequest("POST", w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	if len(w.Secret) > 0 {
+		req.Header.Set(SignatureHeader, "sha256="+Sign(w.Secret, body))
+	}
+
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s replied %s", w.URL, resp.Status)
+	}
+
+	return nil
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of body
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,199 @@
+// Package auditlog writes one record per
+// completed or aborted transfer either in the
+// classic wu-ftpd xferlog format or as JSON lines.
+// Every method of a nil *Logger is a no-op so the
+// session does not need to check if the audit log
+// is enabled.
+package auditlog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Transfer directions
+const (
+	Download = "download"
+	Upload   = "upload"
+)
+
+// Transfer types
+const (
+	ASCII  = "ascii"
+	Binary = "binary"
+)
+
+// Format is the output format of a Logger
+type Format int
+
+// Supported formats
+const (
+	FormatXferlog Format = iota
+	FormatJSON
+)
+
+// ParseFormat converts the format name
+// (xferlog or json) to a Format
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(name) {
+	case "xferlog":
+		return FormatXferlog, nil
+	case "json":
+		return FormatJSON, nil
+	}
+	return FormatXferlog, fmt.Errorf("unsupported audit log format %s", name)
+}
+
+// Record is a single transfer. Checksum is the digest
+// of the transferred bytes computed with Algorithm.
+type Record struct {
+	Time         time.Time     `json:"time"`
+	Duration     time.Duration `json:"-"`
+	RemoteHost   string        `json:"remote_host"`
+	Bytes        int64         `json:"bytes"`
+	Path         string        `json:"path"`
+	Direction    string        `json:"direction"`
+	TransferType string        `json:"transfer_type"`
+	User         string        `json:"user"`
+	TLS          bool          `json:"tls"`
+	Algorithm    string        `json:"checksum_algorithm,omitempty"`
+	Checksum     string        `json:"checksum,omitempty"`
+	Completed    bool          `json:"completed"`
+}
+
+// Logger writes the records to its destination
+type Logger struct {
+	mutex  sync.Mutex
+	w      io.Writer
+	format Format
+}
+
+// New creates a Logger writing to w
+func New(w io.Writer, format Format) *Logger {
+	return &Logger{
+		w:      w,
+		format: format,
+	}
+}
+
+// Open creates a Logger appending
+// to the file at path
+func Open(path string, format Format) (*Logger, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return nil, err
+	}
+	return New(f, format), nil
+}
+
+// Close closes the destination
+// if it is an io.Closer
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if c, ok := l.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// Log writes the record r
+func (l *Logger) Log(r Record) error {
+	if l == nil {
+		return nil
+	}
+
+	var line []byte
+	if l.format == FormatJSON {
+		b, err := json.Marshal(jsonRecord{
+			Record:          r,
+			DurationSeconds: r.Duration.Seconds(),
+		})
+		if err != nil {
+			return err
+		}
+		line = append(b, '\n')
+	} else {
+		line = []byte(xferlogLine(r))
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	_, err := l.w.Write(line)
+	return err
+}
+
+type jsonRecord struct {
+	Record
+	DurationSeconds float64 `json:"duration_seconds"`
+}
+
+// xferlogLine formats r as a wu-ftpd xferlog line:
+// current-time transfer-time remote-host file-size filename
+// transfer-type special-action-flag direction access-mode
+// username service-name authentication-method
+// authenticated-user-id completion-status
+func xferlogLine(r Record) string {
+	transferType := "b"
+	if r.TransferType == ASCII {
+		transferType = "a"
+	}
+
+	direction := "o"
+	if r.Direction == Upload {
+		direction = "i"
+	}
+
+	accessMode := "r"
+	if r.User == "anonymous" || r.User == "ftp" {
+		accessMode = "a"
+	}
+
+	status := "i"
+	if r.Completed {
+		status = "c"
+	}
+
+	// at least one second, as wu-ftpd does
+	seconds := int64(r.Duration.Seconds() + 0.5)
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	return fmt.Sprintf("%s %d %s %d %s %s _ %s %s %s ftp 0 * %s\n",
+		r.Time.Format("Mon Jan _2 15:04:05 2006"),
+		seconds,
+		field(r.RemoteHost),
+		r.Bytes,
+		field(r.Path),
+		transferType,
+		direction,
+		accessMode,
+		field(r.User),
+		status)
+}
+
+// field replaces the spaces so the
+// xferlog columns can be split on blanks
+func field(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '\t' || r == '\r' || r == '\n' {
+			return '_'
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,71 @@
+package auditlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var record = Record{
+	Time:         time.Date(2016, time.March, 5, 9, 4, 2, 0, time.UTC),
+	Duration:     2600 * time.Millisecond,
+	RemoteHost:   "10.0.0.1",
+	Bytes:        1024,
+	Path:         "/dir/my file.txt",
+	Direction:    Upload,
+	TransferType: Binary,
+	User:         "frank",
+	TLS:          true,
+	Algorithm:    "SHA-256",
+	Checksum:     "abcd",
+	Completed:    true,
+}
+
+func TestXferlog(t *testing.T) {
+	buf := new(bytes.Buffer)
+	assert.NoError(t, New(buf, FormatXferlog).Log(record))
+	assert.Equal(t, "Sat Mar  5 09:04:02 2016 3 10.0.0.1 1024 /dir/my_file.txt b _ i r frank ftp 0 * c\n", buf.String())
+
+	buf.Reset()
+	r := record
+	r.Direction = Download
+	r.TransferType = ASCII
+	r.User = "anonymous"
+	r.Duration = 0
+	r.Completed = false
+	assert.NoError(t, New(buf, FormatXferlog).Log(r))
+	assert.Equal(t, "Sat Mar  5 09:04:02 2016 1 10.0.0.1 1024 /dir/my_file.txt a _ o a anonymous ftp 0 * i\n", buf.String())
+}
+
+func TestJSON(t *testing.T) {
+	buf := new(bytes.Buffer)
+	assert.NoError(t, New(buf, FormatJSON).Log(record))
+
+	var out map[string]interface{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &out))
+	assert.Equal(t, "/dir/my file.txt", out["path"])
+	assert.Equal(t, 2.6, out["duration_seconds"])
+	assert.Equal(t, "upload", out["direction"])
+	assert.Equal(t, true, out["tls"])
+	assert.Equal(t, "abcd", out["checksum"])
+	assert.Equal(t, true, out["completed"])
+	assert.Equal(t, "2016-03-05T09:04:02Z", out["time"])
+}
+
+func TestParseFormat(t *testing.T) {
+	f, err := ParseFormat("JSON")
+	assert.NoError(t, err)
+	assert.Equal(t, FormatJSON, f)
+
+	_, err = ParseFormat("csv")
+	assert.Error(t, err)
+}
+
+func TestNilLogger(t *testing.T) {
+	var l *Logger
+	assert.NoError(t, l.Log(record))
+	assert.NoError(t, l.Close())
+}
@@ -0,0 +1,176 @@
+// Package certstore holds the TLS certificates
+// of the server. The certificates can be reloaded from
+// their files without restarting the server and are
+// selected by the SNI name sent by the client.
+package certstore
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type entry struct {
+	certFile string
+	keyFile  string
+	cert     *tls.Certificate
+	modTime  time.Time
+}
+
+// Store is a set of certificates. Use
+// GetCertificate as tls.Config.GetCertificate.
+type Store struct {
+	mu      sync.RWMutex
+	entries []*entry
+}
+
+// New creates an empty Store
+func New() *Store {
+	return &Store{}
+}
+
+// Add loads a certificate and its key. The first
+// certificate added is the default one, presented to the
+// clients that do not send SNI or send an unknown name.
+func (s *Store) Add(certFile, keyFile string) error {
+	e := &entry{certFile: certFile, keyFile: keyFile}
+	if err := e.load(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = append(s.entries, e)
+	return nil
+}
+
+// Len returns the number of certificates
+func (s *Store) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.entries)
+}
+
+// Reload reads again every certificate. A certificate that
+// cannot be loaded (for example because the renewal is still
+// in progress) keeps its previous version and the first
+// error is returned.
+func (s *Store) Reload() error {
+	return s.reload(false)
+}
+
+// ReloadChanged reads again the certificates whose
+// files have been modified since the last load
+func (s *Store) ReloadChanged() error {
+	return s.reload(true)
+}
+
+func (s *Store) reload(onlyChanged bool) error {
+	s.mu.RLock()
+	entries := make([]*entry, len(s.entries))
+	copy(entries, s.entries)
+	s.mu.RUnlock()
+
+	var firstErr error
+	for i, old := range entries {
+		if onlyChanged && !old.changed() {
+			continue
+		}
+
+		e := &entry{certFile: old.certFile, keyFile: old.keyFile}
+		if err := e.load(); err != nil {
+			log.WithFields(log.Fields{"certFile": e.certFile, "keyFile": e.keyFile, "err": err}).Warn("certstore::Store::reload cannot load certificate, keeping the previous one")
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		s.mu.Lock()
+		if i < len(s.entries) && s.entries[i] == old {
+			s.entries[i] = e
+		}
+		s.mu.Unlock()
+
+		log.WithFields(log.Fields{"certFile": e.certFile, "subject": e.cert.Leaf.Subject.String(), "notAfter": e.cert.Leaf.NotAfter}).Info("certstore::Store::reload certificate loaded")
+	}
+
+	return firstErr
+}
+
+// Watch checks every interval if the certificate
+// files have changed and reloads them. It returns
+// when stop is closed.
+func (s *Store) Watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.ReloadChanged()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// GetCertificate returns the certificate matching the
+// SNI name of the client, or the default one
+func (s *Store) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if len(s.entries) == 0 {
+		return nil, fmt.Errorf("no certificates available")
+	}
+
+	if hello.ServerName != "" {
+		for _, e := range s.entries {
+			if e.cert.Leaf.VerifyHostname(hello.ServerName) == nil {
+				return e.cert, nil
+			}
+		}
+	}
+
+	return s.entries[0].cert, nil
+}
+
+func (e *entry) load() error {
+	cert, err := tls.LoadX509KeyPair(e.certFile, e.keyFile)
+	if err != nil {
+		return err
+	}
+
+	if cert.Leaf == nil {
+		cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return err
+		}
+	}
+
+	e.cert = &cert
+	e.modTime = e.lastModified()
+	return nil
+}
+
+// lastModified returns the most recent
+// modification time of the two files
+func (e *entry) lastModified() time.Time {
+	var t time.Time
+	for _, name := range []string{e.certFile, e.keyFile} {
+		if fi, err := os.Stat(name); err == nil && fi.ModTime().After(t) {
+			t = fi.ModTime()
+		}
+	}
+	return t
+}
+
+func (e *entry) changed() bool {
+	return !e.lastModified().Equal(e.modTime)
+}
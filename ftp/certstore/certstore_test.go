@@ -0,0 +1,97 @@
+package certstore
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeCertificate writes a self signed certificate
+// for host in dir and returns the file names
+func writeCertificate(t *testing.T, dir, host string, serial int64) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	certFile := filepath.Join(dir, host+".crt")
+	keyFile := filepath.Join(dir, host+".key")
+	assert.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+
+	return certFile, keyFile
+}
+
+func TestSNI(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certstore")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s := New()
+	assert.NoError(t, s.Add(writeCertificate(t, dir, "ftp.example.com", 1)))
+	assert.NoError(t, s.Add(writeCertificate(t, dir, "ftp.example.org", 2)))
+	assert.Equal(t, 2, s.Len())
+
+	cert, err := s.GetCertificate(&tls.ClientHelloInfo{ServerName: "ftp.example.org"})
+	assert.NoError(t, err)
+	assert.Equal(t, "ftp.example.org", cert.Leaf.Subject.CommonName)
+
+	// default certificate
+	cert, err = s.GetCertificate(&tls.ClientHelloInfo{})
+	assert.NoError(t, err)
+	assert.Equal(t, "ftp.example.com", cert.Leaf.Subject.CommonName)
+
+	cert, err = s.GetCertificate(&tls.ClientHelloInfo{ServerName: "unknown.example.net"})
+	assert.NoError(t, err)
+	assert.Equal(t, "ftp.example.com", cert.Leaf.Subject.CommonName)
+}
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certstore")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := writeCertificate(t, dir, "ftp.example.com", 1)
+
+	s := New()
+	assert.NoError(t, s.Add(certFile, keyFile))
+
+	// renewal
+	writeCertificate(t, dir, "ftp.example.com", 2)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, future, future)
+
+	assert.NoError(t, s.ReloadChanged())
+	cert, _ := s.GetCertificate(&tls.ClientHelloInfo{})
+	assert.Equal(t, int64(2), cert.Leaf.SerialNumber.Int64())
+
+	// a broken file keeps the previous certificate
+	assert.NoError(t, ioutil.WriteFile(certFile, []byte("garbage"), 0600))
+	assert.Error(t, s.Reload())
+	cert, _ = s.GetCertificate(&tls.ClientHelloInfo{})
+	assert.Equal(t, int64(2), cert.Leaf.SerialNumber.Int64())
+}
@@ -0,0 +1,95 @@
+package datachannel
+
+import (
+	"io"
+	"runtime"
+)
+
+// NewASCIIWriter returns an io.Writer that converts
+// the line endings to CRLF (the FTP ASCII representation)
+// before writing to w. Existing CRLF sequences are left
+// untouched.
+func NewASCIIWriter(w io.Writer) io.Writer {
+	return &asciiWriter{w: w}
+}
+
+// NewASCIIReader returns an io.Reader that converts
+// the CRLF line endings read from r to the native line
+// ending of the server (LF everywhere but Windows).
+func NewASCIIReader(r io.Reader) io.Reader {
+	if runtime.GOOS == "windows" {
+		return r
+	}
+
+	return &asciiReader{r: r, buf: make([]byte, 32*1024)}
+}
+
+type asciiWriter struct {
+	w      io.Writer
+	lastCR bool
+	buf    []byte
+}
+
+func (a *asciiWriter) Write(p []byte) (int, error) {
+	a.buf = a.buf[:0]
+
+	for _, c := range p {
+		if c == '\n' && !a.lastCR {
+			a.buf = append(a.buf, '\r')
+		}
+		a.buf = append(a.buf, c)
+		a.lastCR = c == '\r'
+	}
+
+	if _, err := a.w.Write(a.buf); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+type asciiReader struct {
+	r         io.Reader
+	buf       []byte
+	out       []byte // converted bytes not yet returned
+	pendingCR bool   // CR read but not yet known if followed by LF
+	err       error
+}
+
+func (a *asciiReader) Read(p []byte) (int, error) {
+	for len(a.out) == 0 {
+		if a.err != nil {
+			if a.pendingCR {
+				// lone CR at the end of the stream
+				a.pendingCR = false
+				a.out = append(a.out, '\r')
+				break
+			}
+			return 0, a.err
+		}
+
+		n, err := a.r.Read(a.buf)
+		a.err = err
+
+		for _, c := range a.buf[:n] {
+			if a.pendingCR {
+				a.pendingCR = false
+				if c != '\n' {
+					a.out = append(a.out, '\r')
+				}
+			}
+
+			if c == '\r' {
+				a.pendingCR = true
+				continue
+			}
+
+			a.out = append(a.out, c)
+		}
+	}
+
+	n := copy(p, a.out)
+	a.out = a.out[n:]
+
+	return n, nil
+}
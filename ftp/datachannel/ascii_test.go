@@ -0,0 +1,34 @@
+package datachannel
+
+import (
+	"bytes"
+	"io/ioutil"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestASCIIWriter(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewASCIIWriter(buf)
+
+	w.Write([]byte("first\nsecond\r"))
+	w.Write([]byte("\nthird\n"))
+
+	assert.Equal(t, "first\r\nsecond\r\nthird\r\n", buf.String())
+}
+
+func TestASCIIReader(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("CRLF is the native line ending")
+	}
+
+	r := NewASCIIReader(strings.NewReader("first\r\nsecond\rthird\r\n\r"))
+
+	b, err := ioutil.ReadAll(r)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "first\nsecond\rthird\n\r", string(b))
+}
@@ -4,9 +4,11 @@ package datachannel
 
 import (
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/mindflavor/ftpserver2/ftp/portassigner"
@@ -30,11 +32,24 @@ type DataChanneler interface {
 	IsClosed() bool
 	Encrypted() bool
 	SetEncrypted(encrypt bool)
+	OnRefused(f func(err error))
+	OnTransfer(f TransferFunc)
 }
 
+// TransferFunc is called when the SinkFunction
+// returns with the bytes read from and written to
+// the data connection and the transfer duration
+type TransferFunc func(read, written int64, elapsed time.Duration)
+
+// ErrNotResumed is passed to the OnRefused function
+// when an encrypted data connection does not resume
+// the TLS session of the control connection
+var ErrNotResumed = errors.New("data connection did not resume the control connection TLS session")
+
 type dataChannel struct {
 	pa               portassigner.PortAssigner
-	cert             *tls.Certificate
+	tlsConfig        *tls.Config
+	requireResume    bool
 	port             int
 	listener         net.Listener
 	connection       net.Conn
@@ -42,12 +57,17 @@ type dataChannel struct {
 	encrypted        bool
 	fncChan          chan (SinkFunction)
 	killChan         chan (bool)
+	refusedFunc      func(err error)
+	transferFunc     TransferFunc
 }
 
 // New initializes a new DataChanneler
 // You must call Open before calling the Sink
-// method or the socket won't be open (nor accepting connections)
-func New(pa portassigner.PortAssigner, cert *tls.Certificate, encrypted bool) (DataChanneler, error) {
+// method or the socket won't be open (nor accepting connections).
+// tlsConfig should be the one of the control connection so
+// the client can resume its TLS session. If requireResume is true
+// encrypted connections that do not resume it are refused.
+func New(pa portassigner.PortAssigner, tlsConfig *tls.Config, encrypted bool, requireResume bool) (DataChanneler, error) {
 	log.WithFields(log.Fields{"PortAssigner": pa}).Debug("DataChannel::New called")
 	port, err := pa.AssignPort()
 
@@ -65,7 +85,8 @@ func New(pa portassigner.PortAssigner, cert *tls.Certificate, encrypted bool) (D
 		fncChan:          nil,
 		encrypted:        encrypted,
 		killChan:         make(chan (bool), 100),
-		cert:             cert,
+		tlsConfig:        tlsConfig,
+		requireResume:    requireResume,
 	}, nil
 }
 
@@ -86,6 +107,25 @@ func (dc *dataChannel) SetEncrypted(encrypt bool) {
 	dc.encrypted = encrypt
 }
 
+// OnRefused sets the function called, instead of the
+// SinkFunction, when the data connection is refused
+func (dc *dataChannel) OnRefused(f func(err error)) {
+	dc.refusedFunc = f
+}
+
+// OnTransfer sets the function called
+// after the SinkFunction returns
+func (dc *dataChannel) OnTransfer(f TransferFunc) {
+	dc.transferFunc = f
+}
+
+// refuse notifies the OnRefused function, if any
+func (dc *dataChannel) refuse(err error) {
+	if dc.refusedFunc != nil {
+		dc.refusedFunc(err)
+	}
+}
+
 func (dc *dataChannel) ToPASVStringPort() string {
 	//227 Entering Passive Mode (131,175,31,10,193,167)
 	iHigh := dc.port >> 8
@@ -136,22 +176,41 @@ func (dc *dataChannel) Open() error {
 			// handle encryption if needed
 
 			if dc.encrypted {
-				if dc.cert == nil {
-					log.WithFields(log.Fields{"conn": conn, "err": err, "dataChannel": dc}).Warn("datachannel::DataChannel::OpenAndSend goroutine error: cannot encrypt connection without proper certificate (dc.cert == nil)")
+				if dc.tlsConfig == nil {
+					log.WithFields(log.Fields{"conn": conn, "err": err, "dataChannel": dc}).Warn("datachannel::DataChannel::OpenAndSend goroutine error: cannot encrypt connection without a TLS configuration (dc.tlsConfig == nil)")
 					return
 				}
 
-				sslConfig := tls.Config{Certificates: []tls.Certificate{*dc.cert}}
+				tlsConn := tls.Server(conn, dc.tlsConfig)
+				conn = tlsConn
+				dc.secureConnection = conn // store for deletion
 
-				log.WithFields(log.Fields{"dc": dc, "sslConfig": sslConfig}).Debug("datachannel::dataChannel::Open sslConfig created")
+				log.WithFields(log.Fields{"dc": dc}).Debug("datachannel::dataChannel::Open tls.Server created")
 
-				conn = tls.Server(conn, &sslConfig)
-				dc.secureConnection = conn // store for deletion
+				if dc.requireResume {
+					if err := tlsConn.Handshake(); err != nil {
+						log.WithFields(log.Fields{"conn": conn, "err": err, "dataChannel": dc}).Warn("datachannel::DataChannel::OpenAndSend TLS handshake failed")
+						dc.refuse(err)
+						return
+					}
 
-				log.WithFields(log.Fields{"dc": dc, "sslConfig": sslConfig}).Debug("datachannel::dataChannel::Open tls.Server created")
+					if !tlsConn.ConnectionState().DidResume {
+						log.WithFields(log.Fields{"conn": conn, "dataChannel": dc}).Warn("datachannel::DataChannel::OpenAndSend data connection refused: it does not resume the control connection TLS session")
+						dc.refuse(ErrNotResumed)
+						return
+					}
+				}
 			}
 
-			err = f(conn, conn)
+			cr := &countingReader{r: conn}
+			cw := &countingWriter{w: conn}
+			start := time.Now()
+
+			err = f(cw, cr)
+
+			if dc.transferFunc != nil {
+				dc.transferFunc(cr.n, cw.n, time.Since(start))
+			}
 			if err != nil {
 				log.WithFields(log.Fields{"conn": conn, "err": err, "dataChannel": dc}).Warn("datachannel::DataChannel::OpenAndSend goroutine error")
 			}
@@ -203,3 +262,25 @@ func (dc *dataChannel) Close() error {
 
 	return nil
 }
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
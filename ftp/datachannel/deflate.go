@@ -0,0 +1,49 @@
+package datachannel
+
+import (
+	"compress/zlib"
+	"io"
+)
+
+// NewDeflateWriter wraps w so that the data written
+// is compressed with zlib (MODE Z). The returned writer
+// must be closed to flush the compressed stream;
+// closing it does not close w.
+func NewDeflateWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	return zlib.NewWriterLevel(w, level)
+}
+
+// NewDeflateReader wraps r so that the data read
+// is decompressed with zlib (MODE Z). Closing the
+// returned reader does not close r. The zlib header
+// is read by the first Read: the client sends it only
+// after the 150 reply.
+func NewDeflateReader(r io.Reader) (io.ReadCloser, error) {
+	return &deflateReader{r: r}, nil
+}
+
+// deflateReader creates the zlib
+// reader on the first Read
+type deflateReader struct {
+	r  io.Reader
+	zr io.ReadCloser
+}
+
+func (d *deflateReader) Read(p []byte) (int, error) {
+	if d.zr == nil {
+		zr, err := zlib.NewReader(d.r)
+		if err != nil {
+			return 0, err
+		}
+		d.zr = zr
+	}
+
+	return d.zr.Read(p)
+}
+
+func (d *deflateReader) Close() error {
+	if d.zr == nil {
+		return nil
+	}
+	return d.zr.Close()
+}
@@ -0,0 +1,37 @@
+package datachannel
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeflateRoundTrip(t *testing.T) {
+	payload := strings.Repeat("compress me please\r\n", 1000)
+
+	buf := new(bytes.Buffer)
+	w, err := NewDeflateWriter(buf, zlib.BestCompression)
+	assert.NoError(t, err)
+
+	w.Write([]byte(payload))
+	assert.NoError(t, w.Close())
+	assert.True(t, buf.Len() < len(payload))
+
+	r, err := NewDeflateReader(buf)
+	assert.NoError(t, err)
+
+	b, err := ioutil.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, payload, string(b))
+}
+
+func TestDeflateReaderIsLazy(t *testing.T) {
+	// nothing is read until the first Read
+	r, err := NewDeflateReader(new(bytes.Buffer))
+	assert.NoError(t, err)
+	assert.NoError(t, r.Close())
+}
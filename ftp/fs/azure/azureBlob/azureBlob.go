@@ -3,9 +3,12 @@
 package azureBlob
 
 import (
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"os"
+	"strings"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -15,26 +18,34 @@ import (
 )
 
 type azureBlob struct {
-	name    string
-	path    string
-	size    int64
-	modTime time.Time
-	mode    os.FileMode
-	client  storage.BlobStorageClient
+	name       string
+	path       string
+	size       int64
+	modTime    time.Time
+	mode       os.FileMode
+	contentMD5 string
+	owner      string
+	group      string
+	client     storage.BlobStorageClient
 }
 
 // New initializes a new fs.File with the
-// specified parameters.
-func New(name string, path string, size int64, modTime time.Time, mode os.FileMode, client storage.BlobStorageClient) fs.File {
-	log.WithFields(log.Fields{"name": name, "path": path, "size": size, "modTime": modTime, "mode": mode}).Debug("azureBlob::New called")
+// specified parameters. contentMD5 is the base64
+// encoded Content-MD5 blob property (empty if unknown),
+// owner and group are the virtual owner of the blob.
+func New(name string, path string, size int64, modTime time.Time, mode os.FileMode, contentMD5 string, owner, group string, client storage.BlobStorageClient) fs.File {
+	log.WithFields(log.Fields{"name": name, "path": path, "size": size, "modTime": modTime, "mode": mode, "contentMD5": contentMD5}).Debug("azureBlob::New called")
 
 	return &azureBlob{
-		name:    name,
-		path:    path,
-		size:    size,
-		modTime: modTime,
-		mode:    mode,
-		client:  client,
+		name:       name,
+		path:       path,
+		size:       size,
+		modTime:    modTime,
+		mode:       mode,
+		contentMD5: contentMD5,
+		owner:      owner,
+		group:      group,
+		client:     client,
 	}
 }
 
@@ -70,24 +81,133 @@ func (b *azureBlob) Mode() string {
 	return b.mode.String()
 }
 
+// Owner implements fs.Owned
+func (b *azureBlob) Owner() string {
+	return b.owner
+}
+
+// Group implements fs.Owned
+func (b *azureBlob) Group() string {
+	return b.group
+}
+
+// Links implements fs.Owned
+func (b *azureBlob) Links() int {
+	return 1
+}
+
 func (b *azureBlob) Read(startPosition int64) (io.ReadCloser, error) {
 	log.WithFields(log.Fields{"b": b, "startPosition": startPosition}).Debug("azureBlob::azureBlob::Read called")
+
+	if startPosition != 0 {
+		return b.client.GetBlobRange(b.path, b.name, fmt.Sprintf("%d-", startPosition), nil)
+	}
+
 	return b.client.GetBlob(b.path, b.name)
 }
 
+// Hash implements fs.Hasher. MD5 is taken
+// from the stored Content-MD5 property, if present,
+// without reading the blob. Every other algorithm
+// requires downloading the blob.
+func (b *azureBlob) Hash(algorithm string) ([]byte, error) {
+	log.WithFields(log.Fields{"b": b, "algorithm": algorithm}).Debug("azureBlob::azureBlob::Hash called")
+
+	if algorithm == fs.HashMD5 && b.contentMD5 != "" {
+		return base64.StdEncoding.DecodeString(b.contentMD5)
+	}
+
+	if algorithm != fs.HashMD5 {
+		// stored by StoreHash?
+		metadata, err := b.client.GetBlobMetadata(b.path, b.name)
+		if err == nil {
+			if stored, ok := metadata[metadataHashName(algorithm)]; ok {
+				return hex.DecodeString(stored)
+			}
+		}
+	}
+
+	r, err := b.client.GetBlob(b.path, b.name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return fs.HashReader(r, algorithm)
+}
+
 func (b *azureBlob) Write() (io.WriteCloser, error) {
 	log.WithFields(log.Fields{"b": b}).Debug("azureBlob::azureBlob::Write called")
 	return NewBlockBlobWriter(b)
 }
 
+// StoreHash implements fs.HashStorer. MD5 digests are
+// stored in the Content-MD5 blob property, the other
+// algorithms in the blob metadata.
+func (b *azureBlob) StoreHash(algorithm string, digest []byte) error {
+	log.WithFields(log.Fields{"b": b, "algorithm": algorithm}).Debug("azureBlob::azureBlob::StoreHash called")
+
+	if algorithm == fs.HashMD5 {
+		b.contentMD5 = base64.StdEncoding.EncodeToString(digest)
+		return setContentMD5(b.client, b.path, b.name, b.contentMD5)
+	}
+
+	metadata, err := b.client.GetBlobMetadata(b.path, b.name)
+	if err != nil {
+		return err
+	}
+	if metadata == nil {
+		metadata = make(map[string]string)
+	}
+	metadata[metadataHashName(algorithm)] = hex.EncodeToString(digest)
+
+	return b.client.SetBlobMetadata(b.path, b.name, metadata, nil)
+}
+
+// propertiesClient is the part of
+// storage.BlobStorageClient used by setContentMD5
+type propertiesClient interface {
+	GetBlobProperties(container, name string) (*storage.BlobProperties, error)
+	SetBlobProperties(container, name string, blobHeaders storage.BlobHeaders) error
+}
+
+// setContentMD5 changes the Content-MD5 property of a blob.
+// Set Blob Properties clears the headers it is not passed,
+// so the current ones are read and sent back unchanged.
+func setContentMD5(client propertiesClient, container, name, contentMD5 string) error {
+	props, err := client.GetBlobProperties(container, name)
+	if err != nil {
+		return err
+	}
+
+	headers := storage.BlobHeaders{ContentMD5: contentMD5}
+	if props != nil {
+		headers.ContentType = props.ContentType
+		headers.ContentEncoding = props.ContentEncoding
+		headers.ContentLanguage = props.ContentLanguage
+		headers.CacheControl = props.CacheControl
+	}
+
+	return client.SetBlobProperties(container, name, headers)
+}
+
+// metadataHashName returns the metadata key used
+// to store the digest (metadata keys must be valid C# identifiers)
+func metadataHashName(algorithm string) string {
+	return "ftphash" + strings.ToLower(strings.Replace(algorithm, "-", "", -1))
+}
+
 func (b *azureBlob) Clone() fs.File {
 	return &azureBlob{
-		name:    b.name,
-		path:    b.path,
-		size:    b.size,
-		modTime: b.modTime,
-		mode:    b.mode,
-		client:  b.client,
+		name:       b.name,
+		path:       b.path,
+		size:       b.size,
+		modTime:    b.modTime,
+		mode:       b.mode,
+		contentMD5: b.contentMD5,
+		owner:      b.owner,
+		group:      b.group,
+		client:     b.client,
 	}
 }
 
@@ -0,0 +1,42 @@
+package azureBlob
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeProperties is an in memory propertiesClient
+type fakeProperties struct {
+	props   storage.BlobProperties
+	headers storage.BlobHeaders
+}
+
+func (c *fakeProperties) GetBlobProperties(container, name string) (*storage.BlobProperties, error) {
+	return &c.props, nil
+}
+
+func (c *fakeProperties) SetBlobProperties(container, name string, blobHeaders storage.BlobHeaders) error {
+	c.headers = blobHeaders
+	return nil
+}
+
+func TestSetContentMD5KeepsHeaders(t *testing.T) {
+	c := &fakeProperties{props: storage.BlobProperties{
+		ContentMD5:      "old",
+		ContentType:     "text/csv",
+		ContentEncoding: "gzip",
+		ContentLanguage: "it",
+		CacheControl:    "no-cache",
+	}}
+
+	assert.NoError(t, setContentMD5(c, "container", "report.csv", "XrY7u+Ae7tCTyyK7j1rNww=="))
+	assert.Equal(t, storage.BlobHeaders{
+		ContentMD5:      "XrY7u+Ae7tCTyyK7j1rNww==",
+		ContentType:     "text/csv",
+		ContentEncoding: "gzip",
+		ContentLanguage: "it",
+		CacheControl:    "no-cache",
+	}, c.headers)
+}
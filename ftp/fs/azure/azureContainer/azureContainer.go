@@ -16,16 +16,21 @@ import (
 type azureContainer struct {
 	name    string
 	modTime time.Time
+	owner   string
+	group   string
 	client  storage.BlobStorageClient
 }
 
 // New initializes a new fs.File with the
-// specified parameters.
-func New(name string, modTime time.Time, client storage.BlobStorageClient) fs.File {
+// specified parameters. owner and group are
+// the virtual owner of the container.
+func New(name string, modTime time.Time, owner, group string, client storage.BlobStorageClient) fs.File {
 	log.WithFields(log.Fields{"name": name, "modTime": modTime}).Debug("azureContainer::New called")
 	return &azureContainer{
 		name:    name,
 		modTime: modTime,
+		owner:   owner,
+		group:   group,
 		client:  client,
 	}
 }
@@ -58,6 +63,21 @@ func (p *azureContainer) Mode() string {
 	return "drwxrwsrwx"
 }
 
+// Owner implements fs.Owned
+func (p *azureContainer) Owner() string {
+	return p.owner
+}
+
+// Group implements fs.Owned
+func (p *azureContainer) Group() string {
+	return p.group
+}
+
+// Links implements fs.Owned
+func (p *azureContainer) Links() int {
+	return 2
+}
+
 func (p *azureContainer) Read(startPosition int64) (io.ReadCloser, error) {
 	return nil, fmt.Errorf("azure container is not readable")
 }
@@ -70,11 +90,21 @@ func (p *azureContainer) Clone() fs.File {
 	return &azureContainer{
 		name:    p.name,
 		modTime: p.modTime,
+		owner:   p.owner,
+		group:   p.group,
 		client:  p.client,
 	}
 }
 
 func (p *azureContainer) Delete() error {
-	// should check if empty first? nah :)
+	lbr, err := p.client.ListBlobs(p.name, storage.ListBlobsParameters{MaxResults: 1})
+	if err != nil {
+		return err
+	}
+
+	if len(lbr.Blobs) > 0 {
+		return fmt.Errorf("container %s is not empty", p.name)
+	}
+
 	return p.client.DeleteContainer(p.name)
 }
@@ -20,14 +20,27 @@ type azureFS struct {
 	id                   identity.Identity
 	client               storage.BlobStorageClient
 	currentRealDirectory string
+	owner                string
+	group                string
 }
 
+// DefaultOwner is the virtual owner (and group)
+// of the containers and blobs created by New
+const DefaultOwner = "ftp"
+
 func (pfs *azureFS) String() string {
 	return fmt.Sprintf("id:%s, currentRealDirectory: %s", pfs.id, pfs.currentRealDirectory)
 }
 
 // New initializes a new fs.FileProvider with a specific Azure account and key
 func New(account, secret string) (fs.FileProvider, error) {
+	return NewWithOwner(account, secret, DefaultOwner, DefaultOwner)
+}
+
+// NewWithOwner works like New but lists every
+// container and blob as owned by owner and group
+// (Azure storage has no file ownership).
+func NewWithOwner(account, secret, owner, group string) (fs.FileProvider, error) {
 	cli, err := storage.NewClient(account, secret, storage.DefaultBaseURL, storage.DefaultAPIVersion, true)
 	if err != nil {
 		return nil, err
@@ -37,6 +50,8 @@ func New(account, secret string) (fs.FileProvider, error) {
 		id:                   nil,
 		client:               cli.GetBlobService(),
 		currentRealDirectory: "",
+		owner:                owner,
+		group:                group,
 	}, nil
 }
 
@@ -51,48 +66,75 @@ func (pfs *azureFS) CurrentDirectory() string {
 	return "/" + pfs.currentRealDirectory
 }
 
-func (pfs *azureFS) List() ([]fs.File, error) {
-	if pfs.CurrentDirectory() == "/" {
-		// list containers
+// listPageSize is the number of containers
+// or blobs requested to Azure at once
+const listPageSize = 1000
 
-		// TODO
-		// we should check for more than 1000 entries
-		lcParams := storage.ListContainersParameters{MaxResults: 1000}
-		lbr, err := pfs.client.ListContainers(lcParams)
-		if err != nil {
-			return nil, err
-		}
+func (pfs *azureFS) List() ([]fs.File, error) {
+	var files []fs.File
+	err := pfs.ListEach(func(f fs.File) error {
+		files = append(files, f)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
 
-		cnts := make([]fs.File, len(lbr.Containers))
+	return files, nil
+}
 
-		for i, item := range lbr.Containers {
-			cnts[i] = azureContainer.New(item.Name, parseAzureTime(item.Properties.LastModified), pfs.client)
+// ListEach implements fs.ListStreamer following
+// the Azure continuation markers one page at a time
+func (pfs *azureFS) ListEach(fn func(f fs.File) error) error {
+	if pfs.CurrentDirectory() == "/" {
+		// list containers
+		lcParams := storage.ListContainersParameters{MaxResults: listPageSize}
+		for {
+			lcr, err := pfs.client.ListContainers(lcParams)
+			if err != nil {
+				return err
+			}
+
+			for _, item := range lcr.Containers {
+				if err := fn(azureContainer.New(item.Name, parseAzureTime(item.Properties.LastModified), pfs.owner, pfs.group, pfs.client)); err != nil {
+					return err
+				}
+			}
+
+			if lcr.NextMarker == "" {
+				return nil
+			}
+			lcParams.Marker = lcr.NextMarker
 		}
-
-		return cnts, nil
 	}
 
 	// files!
 	toks := splitAndCleanPath(pfs.currentRealDirectory)
-	var lbParams storage.ListBlobsParameters
-	if len(toks) == 1 {
-		lbParams = storage.ListBlobsParameters{MaxResults: 1000, Delimiter: "/"}
-	} else {
-		lbParams = storage.ListBlobsParameters{MaxResults: 1000, Prefix: strings.Join(toks[1:], "/") + "/", Delimiter: "/"}
-	}
-	lbr, err := pfs.client.ListBlobs(toks[0], lbParams)
-	if err != nil {
-		return nil, err
+	lbParams := storage.ListBlobsParameters{MaxResults: listPageSize, Delimiter: "/"}
+	if len(toks) > 1 {
+		lbParams.Prefix = strings.Join(toks[1:], "/") + "/"
 	}
 
-	blobs := make([]fs.File, len(lbr.Blobs))
+	for {
+		lbr, err := pfs.client.ListBlobs(toks[0], lbParams)
+		if err != nil {
+			return err
+		}
 
-	for i, item := range lbr.Blobs {
-		toks := splitAndCleanPath(item.Name)
-		blobs[i] = azureBlob.New(toks[len(toks)-1], pfs.currentRealDirectory, item.Properties.ContentLength, parseAzureTime(item.Properties.LastModified), 0666, pfs.client)
-	}
+		for _, item := range lbr.Blobs {
+			toks := splitAndCleanPath(item.Name)
+			if err := fn(azureBlob.New(toks[len(toks)-1], pfs.currentRealDirectory, item.Properties.ContentLength, parseAzureTime(item.Properties.LastModified), 0666, item.Properties.ContentMD5, pfs.owner, pfs.group, pfs.client)); err != nil {
+				return err
+			}
+		}
+
+		log.WithFields(log.Fields{"pfs": pfs, "len(lbr.Blobs)": len(lbr.Blobs), "NextMarker": lbr.NextMarker}).Debug("azureFS::azureFS::ListEach page listed")
 
-	return blobs, nil
+		if lbr.NextMarker == "" {
+			return nil
+		}
+		lbParams.Marker = lbr.NextMarker
+	}
 }
 
 func (pfs *azureFS) Get(filename string) (fs.File, error) {
@@ -105,10 +147,10 @@ func (pfs *azureFS) Get(filename string) (fs.File, error) {
 	log.WithFields(log.Fields{"pfs": pfs, "filename": filename, "fullpath": fullpath, "toks": toks}).Debug("azureFS::azureFS::Get called")
 
 	if len(toks) == 0 { // root
-		return azureContainer.New("", time.Now(), pfs.client), nil
+		return azureContainer.New("", time.Now(), pfs.owner, pfs.group, pfs.client), nil
 	}
 	if len(toks) == 1 { // containter
-		return azureContainer.New(filename, time.Now(), pfs.client), nil
+		return azureContainer.New(filename, time.Now(), pfs.owner, pfs.group, pfs.client), nil
 	}
 
 	// else blob
@@ -116,7 +158,7 @@ func (pfs *azureFS) Get(filename string) (fs.File, error) {
 	if err != nil {
 		return nil, err
 	}
-	return azureBlob.New(strings.Join(toks[1:], "/"), toks[0], props.ContentLength, parseAzureTime(props.LastModified), 0666, pfs.client), nil
+	return azureBlob.New(strings.Join(toks[1:], "/"), toks[0], props.ContentLength, parseAzureTime(props.LastModified), 0666, props.ContentMD5, pfs.owner, pfs.group, pfs.client), nil
 }
 
 func (pfs *azureFS) New(filename string, isDirectory bool) (fs.File, error) {
@@ -130,10 +172,10 @@ func (pfs *azureFS) New(filename string, isDirectory bool) (fs.File, error) {
 	toks := splitAndCleanPath(fullpath)
 
 	if len(toks) == 1 { // container
-		return azureContainer.New(filename, time.Now(), pfs.client), nil
+		return azureContainer.New(filename, time.Now(), pfs.owner, pfs.group, pfs.client), nil
 	}
 
-	return azureBlob.New(strings.Join(toks[1:], "/"), toks[0], 0, time.Now(), 0666, pfs.client), nil
+	return azureBlob.New(strings.Join(toks[1:], "/"), toks[0], 0, time.Now(), 0666, "", pfs.owner, pfs.group, pfs.client), nil
 }
 
 func (pfs *azureFS) Clone() fs.FileProvider {
@@ -141,6 +183,8 @@ func (pfs *azureFS) Clone() fs.FileProvider {
 		id:                   pfs.id,
 		client:               pfs.client,
 		currentRealDirectory: pfs.currentRealDirectory,
+		owner:                pfs.owner,
+		group:                pfs.group,
 	}
 }
 
@@ -236,13 +280,121 @@ func (pfs *azureFS) RemoveDirectory(path string) error {
 
 	log.WithFields(log.Fields{"pfs": pfs, "path": path, "fullpath": fullpath}).Debug("azureFS::azureFS::RemoveDirectory called")
 
+	container, blobName, err := splitDirectory(fullpath)
+	if err != nil {
+		return err
+	}
+
+	// Virtual directory (placeholder blob)
+	if blobName != "" {
+		exists, err := pfs.client.BlobExists(container, blobName)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("directory not found")
+		}
+	}
+
+	empty, err := isEmpty(pfs.client, container, childrenPrefix(blobName))
+	if err != nil {
+		return err
+	}
+	if !empty {
+		return fmt.Errorf("directory not empty")
+	}
+
+	// Container
+	if blobName == "" {
+		return pfs.client.DeleteContainer(container)
+	}
+
+	return pfs.client.DeleteBlob(container, blobName, nil)
+}
+
+// RemoveDirectoryRecursive implements fs.RecursiveRemover.
+// It deletes every blob below the directory and then the
+// directory itself (either the placeholder blob or the container).
+func (pfs *azureFS) RemoveDirectoryRecursive(path string) error {
+	fullpath := path
+	if fullpath[0] != '/' {
+		fullpath = "/" + pfs.currentRealDirectory + "/" + path
+	}
+
+	log.WithFields(log.Fields{"pfs": pfs, "path": path, "fullpath": fullpath}).Debug("azureFS::azureFS::RemoveDirectoryRecursive called")
+
+	container, blobName, err := splitDirectory(fullpath)
+	if err != nil {
+		return err
+	}
+
+	// Container: deleting it removes every blob inside
+	if blobName == "" {
+		return pfs.client.DeleteContainer(container)
+	}
+
+	lbParams := storage.ListBlobsParameters{MaxResults: 1000, Prefix: childrenPrefix(blobName)}
+	for {
+		lbr, err := pfs.client.ListBlobs(container, lbParams)
+		if err != nil {
+			return err
+		}
+
+		for _, item := range lbr.Blobs {
+			log.WithFields(log.Fields{"pfs": pfs, "container": container, "blob": item.Name}).Debug("azureFS::azureFS::RemoveDirectoryRecursive deleting blob")
+			if err := pfs.client.DeleteBlob(container, item.Name, nil); err != nil {
+				return err
+			}
+		}
+
+		if lbr.NextMarker == "" {
+			break
+		}
+		lbParams.Marker = lbr.NextMarker
+	}
+
+	// the placeholder might be missing if the blobs were
+	// uploaded without a MKD
+	_, err = pfs.client.DeleteBlobIfExists(container, blobName, nil)
+	return err
+}
+
+// splitDirectory returns the container and the placeholder
+// blob name (empty for a container) of the fullpath directory
+func splitDirectory(fullpath string) (string, string, error) {
 	toks := splitAndCleanPath(fullpath)
 
-	if len(toks) > 1 {
-		return fmt.Errorf("there are no subdirectories in Azure storage")
+	if len(toks) == 0 {
+		return "", "", fmt.Errorf("cannot remove the root directory")
+	}
+
+	return toks[0], strings.Join(toks[1:], "/"), nil
+}
+
+// childrenPrefix returns the prefix of the blobs inside
+// the blobName directory (empty for a container)
+func childrenPrefix(blobName string) string {
+	if blobName == "" {
+		return ""
+	}
+	return blobName + "/"
+}
+
+// blobLister is the part of storage.BlobStorageClient
+// used by isEmpty
+type blobLister interface {
+	ListBlobs(container string, params storage.ListBlobsParameters) (storage.BlobListResponse, error)
+}
+
+// isEmpty returns true if there are no blobs
+// in the container starting with prefix
+func isEmpty(client blobLister, container, prefix string) (bool, error) {
+	lbr, err := client.ListBlobs(container, storage.ListBlobsParameters{MaxResults: 1, Prefix: prefix})
+	if err != nil {
+		return false, err
 	}
 
-	return pfs.client.DeleteContainer(path)
+	return len(lbr.Blobs) == 0, nil
 }
 
 func parseAzureTime(tToParse string) time.Time {
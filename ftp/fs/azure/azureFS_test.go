@@ -0,0 +1,64 @@
+package azureFS
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeLister records the ListBlobs
+// parameters and returns blobs
+type fakeLister struct {
+	container string
+	params    storage.ListBlobsParameters
+	blobs     []storage.Blob
+	err       error
+}
+
+func (f *fakeLister) ListBlobs(container string, params storage.ListBlobsParameters) (storage.BlobListResponse, error) {
+	f.container, f.params = container, params
+	return storage.BlobListResponse{Blobs: f.blobs}, f.err
+}
+
+func TestSplitDirectory(t *testing.T) {
+	container, blob, err := splitDirectory("/photos")
+	assert.NoError(t, err)
+	assert.Equal(t, "photos", container)
+	assert.Equal(t, "", blob)
+
+	container, blob, err = splitDirectory("/photos//2016/march/")
+	assert.NoError(t, err)
+	assert.Equal(t, "photos", container)
+	assert.Equal(t, "2016/march", blob)
+
+	_, _, err = splitDirectory("/")
+	assert.Error(t, err)
+}
+
+func TestChildrenPrefix(t *testing.T) {
+	assert.Equal(t, "", childrenPrefix(""))
+	assert.Equal(t, "2016/march/", childrenPrefix("2016/march"))
+}
+
+func TestIsEmpty(t *testing.T) {
+	l := &fakeLister{}
+	empty, err := isEmpty(l, "photos", childrenPrefix("2016"))
+	assert.NoError(t, err)
+	assert.True(t, empty)
+	assert.Equal(t, "photos", l.container)
+	assert.Equal(t, "2016/", l.params.Prefix)
+	assert.Equal(t, uint(1), l.params.MaxResults)
+
+	// the placeholder blob "2016" itself is not below "2016/"
+	l = &fakeLister{blobs: []storage.Blob{{Name: "2016/a.jpg"}}}
+	empty, err = isEmpty(l, "photos", childrenPrefix("2016"))
+	assert.NoError(t, err)
+	assert.False(t, empty)
+
+	l = &fakeLister{err: errors.New("forbidden")}
+	_, err = isEmpty(l, "photos", "")
+	assert.Error(t, err)
+	assert.Equal(t, "", l.params.Prefix)
+}
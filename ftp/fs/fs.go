@@ -4,7 +4,9 @@
 package fs
 
 import (
+	"errors"
 	"io"
+	"os"
 	"time"
 
 	"github.com/mindflavor/ftpserver2/identity"
@@ -30,6 +32,16 @@ type File interface {
 	Mode() string
 }
 
+// Owned is an optional capability of a File.
+// If implemented LIST and MLSD show the owner,
+// the group and the number of hard links of the
+// file (empty strings mean unknown).
+type Owned interface {
+	Owner() string
+	Group() string
+	Links() int
+}
+
 // FileProvider represents the
 // file system handle. It should
 // store the current directory
@@ -46,3 +58,98 @@ type FileProvider interface {
 	CreateDirectory(name string) error
 	RemoveDirectory(name string) error
 }
+
+// ListStreamer is an optional capability of a
+// FileProvider. If implemented the directory listings
+// are sent while the entries are retrieved instead
+// of loading the whole directory in memory first.
+type ListStreamer interface {
+	// ListEach calls fn for each entry of the current
+	// directory, in no particular order, stopping at
+	// the first error returned by fn.
+	ListEach(fn func(f File) error) error
+}
+
+// ListEach calls fn for each entry of the current
+// directory of fp. It uses ListStreamer if supported,
+// List otherwise.
+func ListEach(fp FileProvider, fn func(f File) error) error {
+	if _, ok := Unwrap(fp).(ListStreamer); ok {
+		return fp.(ListStreamer).ListEach(fn)
+	}
+
+	files, err := fp.List()
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RecursiveRemover is an optional capability
+// of a FileProvider. If implemented the FTP Server
+// can remove a directory along with all its
+// content (files and subdirectories).
+type RecursiveRemover interface {
+	RemoveDirectoryRecursive(name string) error
+}
+
+// Renamer is an optional capability of a
+// FileProvider. If implemented the FTP Server
+// supports RNFR and RNTO.
+type Renamer interface {
+	Rename(from, to string) error
+}
+
+// ModeChanger is an optional capability of a
+// FileProvider. If implemented the FTP Server
+// supports SITE CHMOD (permission bits only).
+type ModeChanger interface {
+	Chmod(name string, mode os.FileMode) error
+}
+
+// TimesChanger is an optional capability of a
+// FileProvider. If implemented the FTP Server
+// supports SITE UTIME.
+type TimesChanger interface {
+	Chtimes(name string, mtime time.Time) error
+}
+
+// OwnerChanger is an optional capability of a
+// FileProvider. If implemented the FTP Server
+// supports SITE CHOWN. Either owner or group
+// can be empty (unchanged).
+type OwnerChanger interface {
+	Chown(name string, owner, group string) error
+}
+
+// ErrNotSupported is returned by the Wrapper
+// methods forwarding a missing capability
+var ErrNotSupported = errors.New("operation not supported by the file system")
+
+// Wrapper is implemented by the FileProviders
+// decorating another one. A Wrapper implements every
+// optional capability, forwarding it to the wrapped
+// FileProvider if supported (ErrNotSupported otherwise):
+// check the capabilities on the result of Unwrap.
+type Wrapper interface {
+	Unwrap() FileProvider
+}
+
+// Unwrap returns the FileProvider wrapped,
+// even indirectly, by fp (fp itself if it's
+// not a Wrapper)
+func Unwrap(fp FileProvider) FileProvider {
+	for {
+		w, ok := fp.(Wrapper)
+		if !ok {
+			return fp
+		}
+		fp = w.Unwrap()
+	}
+}
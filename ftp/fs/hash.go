@@ -0,0 +1,98 @@
+package fs
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"strings"
+)
+
+// Hash algorithms names as used by the
+// HASH command (draft-bryan-ftpext-hash)
+const (
+	HashCRC32  = "CRC32"
+	HashMD5    = "MD5"
+	HashSHA1   = "SHA-1"
+	HashSHA256 = "SHA-256"
+	HashSHA512 = "SHA-512"
+)
+
+// HashAlgorithms lists the supported hash
+// algorithms, strongest first
+var HashAlgorithms = []string{
+	HashSHA512,
+	HashSHA256,
+	HashSHA1,
+	HashMD5,
+	HashCRC32,
+}
+
+// Hasher is an optional capability of a File.
+// Implement it if the backend can compute (or
+// already knows) the digest of the file content
+// more efficiently than reading it through the FTP Server.
+type Hasher interface {
+	Hash(algorithm string) ([]byte, error)
+}
+
+// HashStorer is an optional capability of a File.
+// The FTP Server computes the digest of the uploaded data
+// while receiving it and, if the File implements this interface,
+// passes it to StoreHash after the writer has been closed
+// so the backend can persist it.
+type HashStorer interface {
+	StoreHash(algorithm string, digest []byte) error
+}
+
+// ParseHashAlgorithm returns the canonical name
+// of the passed algorithm (case insensitive, dash optional)
+// or an error if not supported.
+func ParseHashAlgorithm(algorithm string) (string, error) {
+	wanted := strings.Replace(strings.ToUpper(algorithm), "-", "", -1)
+	for _, algo := range HashAlgorithms {
+		if strings.Replace(algo, "-", "", -1) == wanted {
+			return algo, nil
+		}
+	}
+
+	return "", fmt.Errorf("unsupported hash algorithm %s", algorithm)
+}
+
+// NewHash returns a new hash.Hash for the
+// specified algorithm
+func NewHash(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case HashCRC32:
+		return crc32.NewIEEE(), nil
+	case HashMD5:
+		return md5.New(), nil
+	case HashSHA1:
+		return sha1.New(), nil
+	case HashSHA256:
+		return sha256.New(), nil
+	case HashSHA512:
+		return sha512.New(), nil
+	}
+
+	return nil, fmt.Errorf("unsupported hash algorithm %s", algorithm)
+}
+
+// HashReader reads r until EOF and returns the digest
+// computed with the specified algorithm
+func HashReader(r io.Reader, algorithm string) ([]byte, error) {
+	h, err := NewHash(algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, err
+	}
+
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,32 @@
+package fs
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseHashAlgorithm(t *testing.T) {
+	algo, err := ParseHashAlgorithm("sha256")
+	assert.NoError(t, err)
+	assert.Equal(t, HashSHA256, algo)
+
+	algo, err = ParseHashAlgorithm("SHA-1")
+	assert.NoError(t, err)
+	assert.Equal(t, HashSHA1, algo)
+
+	_, err = ParseHashAlgorithm("SHA-3")
+	assert.Error(t, err)
+}
+
+func TestHashReader(t *testing.T) {
+	digest, err := HashReader(strings.NewReader("abc"), HashMD5)
+	assert.NoError(t, err)
+	assert.Equal(t, "900150983cd24fb0d6963f7d28e17f72", hex.EncodeToString(digest))
+
+	digest, err = HashReader(strings.NewReader("abc"), HashCRC32)
+	assert.NoError(t, err)
+	assert.Equal(t, "352441c2", hex.EncodeToString(digest))
+}
@@ -4,10 +4,13 @@ package localFS
 
 import (
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"os/user"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -21,6 +24,7 @@ type physicalFS struct {
 	homeRealDirectory    string
 	currentRealDirectory string
 	identity             identity.Identity
+	hashCache            *physicalFile.HashCache
 }
 
 // New initializes a new FileProvider with a specific homepath.
@@ -33,6 +37,18 @@ func New(homepath string) (fs.FileProvider, error) {
 	}, nil
 }
 
+// NewWithHashCache works like New but caches up to
+// hashCacheEntries file digests (see the HASH command)
+// so the same file will not be read again until it changes.
+func NewWithHashCache(homepath string, hashCacheEntries int) (fs.FileProvider, error) {
+	return &physicalFS{
+		homeRealDirectory:    homepath,
+		currentRealDirectory: homepath,
+		identity:             nil,
+		hashCache:            physicalFile.NewHashCache(hashCacheEntries),
+	}, nil
+}
+
 func (pfs *physicalFS) Identity() identity.Identity {
 	return pfs.identity
 }
@@ -66,12 +82,43 @@ func (pfs *physicalFS) List() ([]fs.File, error) {
 	var files []fs.File
 
 	for _, item := range items {
-		files = append(files, physicalFile.New(item.Name(), pfs.currentRealDirectory, item.IsDir(), item.Size(), item.ModTime(), item.Mode()))
+		files = append(files, physicalFile.NewFromFileInfo(pfs.currentRealDirectory, item, pfs.hashCache))
 	}
 
 	return files, nil
 }
 
+// listBatchSize is the number of directory
+// entries read at once by ListEach
+const listBatchSize = 1024
+
+// ListEach implements fs.ListStreamer reading
+// the directory entries in batches (in directory
+// order, List sorts them by name)
+func (pfs *physicalFS) ListEach(fn func(f fs.File) error) error {
+	dir, err := os.Open(pfs.currentRealDirectory)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	for {
+		items, err := dir.Readdir(listBatchSize)
+		for _, item := range items {
+			if err := fn(physicalFile.NewFromFileInfo(pfs.currentRealDirectory, item, pfs.hashCache)); err != nil {
+				return err
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
 func (pfs *physicalFS) Get(filename string) (fs.File, error) {
 	var fullpath string
 	if filename[0] == '/' {
@@ -87,7 +134,7 @@ func (pfs *physicalFS) Get(filename string) (fs.File, error) {
 		return nil, err
 	}
 
-	return physicalFile.New(filepath.Base(fullpath), filepath.Dir(fullpath), f.IsDir(), f.Size(), f.ModTime(), f.Mode()), nil
+	return physicalFile.NewFromFileInfo(filepath.Dir(fullpath), f, pfs.hashCache), nil
 }
 
 func (pfs *physicalFS) New(name string, isDirectory bool) (fs.File, error) {
@@ -104,7 +151,7 @@ func (pfs *physicalFS) New(name string, isDirectory bool) (fs.File, error) {
 			return nil, err
 		}
 	}
-	pfile := physicalFile.New(name, pfs.currentRealDirectory, isDirectory, 0, time.Now(), createMode)
+	pfile := physicalFile.New(name, pfs.currentRealDirectory, isDirectory, 0, time.Now(), createMode, pfs.hashCache)
 
 	if !isDirectory {
 		// create an empty file
@@ -122,6 +169,7 @@ func (pfs *physicalFS) Clone() fs.FileProvider {
 	return &physicalFS{
 		homeRealDirectory:    pfs.homeRealDirectory,
 		currentRealDirectory: pfs.currentRealDirectory,
+		hashCache:            pfs.hashCache,
 	}
 }
 
@@ -167,3 +215,139 @@ func (pfs *physicalFS) CreateDirectory(name string) error {
 func (pfs *physicalFS) RemoveDirectory(name string) error {
 	return os.Remove(filepath.Join(pfs.currentRealDirectory, name))
 }
+
+// RemoveDirectoryRecursive implements fs.RecursiveRemover
+func (pfs *physicalFS) RemoveDirectoryRecursive(name string) error {
+	var fullpath string
+	if name[0] == '/' {
+		fullpath = filepath.Join(pfs.homeRealDirectory, name)
+	} else {
+		fullpath = filepath.Join(pfs.currentRealDirectory, name)
+	}
+
+	log.WithFields(log.Fields{"pfs": pfs, "name": name, "fullpath": fullpath}).Debug("localFS::physicalFS::RemoveDirectoryRecursive called")
+
+	// never allow to wipe the home directory or anything outside it
+	rel, err := filepath.Rel(pfs.homeRealDirectory, fullpath)
+	if err != nil {
+		return err
+	}
+	if rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+		return fmt.Errorf("%s cannot be removed", name)
+	}
+
+	stat, err := os.Stat(fullpath)
+	if err != nil {
+		return err
+	}
+	if !stat.IsDir() {
+		return fmt.Errorf("%s is not a directory", name)
+	}
+
+	return os.RemoveAll(fullpath)
+}
+
+// Rename implements fs.Renamer
+func (pfs *physicalFS) Rename(from, to string) error {
+	fromPath, err := pfs.insideHome(from)
+	if err != nil {
+		return err
+	}
+
+	toPath, err := pfs.insideHome(to)
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{"pfs": pfs, "fromPath": fromPath, "toPath": toPath}).Debug("localFS::physicalFS::Rename called")
+
+	return os.Rename(fromPath, toPath)
+}
+
+// Chmod implements fs.ModeChanger
+func (pfs *physicalFS) Chmod(name string, mode os.FileMode) error {
+	fullpath, err := pfs.insideHome(name)
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{"pfs": pfs, "fullpath": fullpath, "mode": mode}).Debug("localFS::physicalFS::Chmod called")
+
+	return os.Chmod(fullpath, mode.Perm())
+}
+
+// Chtimes implements fs.TimesChanger
+func (pfs *physicalFS) Chtimes(name string, mtime time.Time) error {
+	fullpath, err := pfs.insideHome(name)
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{"pfs": pfs, "fullpath": fullpath, "mtime": mtime}).Debug("localFS::physicalFS::Chtimes called")
+
+	if pfs.hashCache != nil {
+		pfs.hashCache.Invalidate(fullpath)
+	}
+
+	return os.Chtimes(fullpath, mtime, mtime)
+}
+
+// Chown implements fs.OwnerChanger. owner and group
+// are either names or numeric ids.
+func (pfs *physicalFS) Chown(name string, owner, group string) error {
+	fullpath, err := pfs.insideHome(name)
+	if err != nil {
+		return err
+	}
+
+	uid, gid := -1, -1
+
+	if owner != "" {
+		if uid, err = strconv.Atoi(owner); err != nil {
+			u, err := user.Lookup(owner)
+			if err != nil {
+				return err
+			}
+			if uid, err = strconv.Atoi(u.Uid); err != nil {
+				return err
+			}
+		}
+	}
+
+	if group != "" {
+		if gid, err = strconv.Atoi(group); err != nil {
+			g, err := user.LookupGroup(group)
+			if err != nil {
+				return err
+			}
+			if gid, err = strconv.Atoi(g.Gid); err != nil {
+				return err
+			}
+		}
+	}
+
+	log.WithFields(log.Fields{"pfs": pfs, "fullpath": fullpath, "uid": uid, "gid": gid}).Debug("localFS::physicalFS::Chown called")
+
+	return os.Chown(fullpath, uid, gid)
+}
+
+// insideHome returns the real path of name refusing
+// the home directory itself and anything outside it
+func (pfs *physicalFS) insideHome(name string) (string, error) {
+	var fullpath string
+	if name[0] == '/' {
+		fullpath = filepath.Join(pfs.homeRealDirectory, name)
+	} else {
+		fullpath = filepath.Join(pfs.currentRealDirectory, name)
+	}
+
+	rel, err := filepath.Rel(pfs.homeRealDirectory, fullpath)
+	if err != nil {
+		return "", err
+	}
+	if rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+		return "", fmt.Errorf("%s is outside the home directory", name)
+	}
+
+	return fullpath, nil
+}
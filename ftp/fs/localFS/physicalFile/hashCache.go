@@ -0,0 +1,86 @@
+package physicalFile
+
+import (
+	"time"
+
+	"github.com/mindflavor/goserializer"
+)
+
+// HashCache stores the digests already computed
+// for the local files. An entry is valid as long as the
+// file size and modification time do not change.
+// It's safe to share an HashCache between sessions.
+type HashCache struct {
+	maxEntries int
+	entries    map[hashCacheKey]hashCacheEntry
+	handler    serializer.Serializer
+}
+
+type hashCacheKey struct {
+	fullpath  string
+	algorithm string
+}
+
+type hashCacheEntry struct {
+	size    int64
+	modTime time.Time
+	digest  []byte
+}
+
+// NewHashCache creates a new HashCache holding
+// up to maxEntries digests
+func NewHashCache(maxEntries int) *HashCache {
+	return &HashCache{
+		maxEntries: maxEntries,
+		entries:    make(map[hashCacheKey]hashCacheEntry),
+		handler:    serializer.New(),
+	}
+}
+
+// Get returns the cached digest if the file did not
+// change since it was computed
+func (hc *HashCache) Get(fullpath, algorithm string, size int64, modTime time.Time) ([]byte, bool) {
+	ret := hc.handler.Serialize(func() interface{} {
+		entry, ok := hc.entries[hashCacheKey{fullpath: fullpath, algorithm: algorithm}]
+		if !ok || entry.size != size || !entry.modTime.Equal(modTime) {
+			return nil
+		}
+		return entry.digest
+	})
+
+	if ret == nil {
+		return nil, false
+	}
+
+	return ret.([]byte), true
+}
+
+// Put stores a digest
+func (hc *HashCache) Put(fullpath, algorithm string, size int64, modTime time.Time, digest []byte) {
+	hc.handler.Serialize(func() interface{} {
+		key := hashCacheKey{fullpath: fullpath, algorithm: algorithm}
+
+		if _, ok := hc.entries[key]; !ok && len(hc.entries) >= hc.maxEntries {
+			// make room by evicting a random entry
+			for k := range hc.entries {
+				delete(hc.entries, k)
+				break
+			}
+		}
+
+		hc.entries[key] = hashCacheEntry{size: size, modTime: modTime, digest: digest}
+		return nil
+	})
+}
+
+// Invalidate removes every digest of the specified file
+func (hc *HashCache) Invalidate(fullpath string) {
+	hc.handler.Serialize(func() interface{} {
+		for k := range hc.entries {
+			if k.fullpath == fullpath {
+				delete(hc.entries, k)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,70 @@
+//go:build !windows
+// +build !windows
+
+package physicalFile
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+// names caches the uid and gid lookups:
+// a directory listing resolves the same
+// few ids over and over
+var names = struct {
+	sync.Mutex
+	users  map[uint32]string
+	groups map[uint32]string
+}{
+	users:  make(map[uint32]string),
+	groups: make(map[uint32]string),
+}
+
+// ownership returns the owner and group names
+// (the numeric ids if they cannot be resolved)
+// and the number of hard links of info
+func ownership(info os.FileInfo) (string, string, int) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", "", 1
+	}
+
+	return userName(st.Uid), groupName(st.Gid), int(st.Nlink)
+}
+
+func userName(uid uint32) string {
+	names.Lock()
+	defer names.Unlock()
+
+	if name, ok := names.users[uid]; ok {
+		return name
+	}
+
+	id := strconv.FormatUint(uint64(uid), 10)
+	name := id
+	if u, err := user.LookupId(id); err == nil {
+		name = u.Username
+	}
+	names.users[uid] = name
+	return name
+}
+
+func groupName(gid uint32) string {
+	names.Lock()
+	defer names.Unlock()
+
+	if name, ok := names.groups[gid]; ok {
+		return name
+	}
+
+	id := strconv.FormatUint(uint64(gid), 10)
+	name := id
+	if g, err := user.LookupGroupId(id); err == nil {
+		name = g.Name
+	}
+	names.groups[gid] = name
+	return name
+}
@@ -3,9 +3,12 @@
 package physicalFile
 
 import (
+	"encoding/hex"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -20,11 +23,16 @@ type physicalFile struct {
 	size        int64
 	modTime     time.Time
 	mode        os.FileMode
+	hashCache   *HashCache
+	owner       string
+	group       string
+	links       int
 }
 
 // New initializes a new fs.File with the
-// specified parameters.
-func New(name string, path string, isDirectory bool, size int64, modTime time.Time, mode os.FileMode) fs.File {
+// specified parameters. hashCache can be nil
+// (digests will not be cached).
+func New(name string, path string, isDirectory bool, size int64, modTime time.Time, mode os.FileMode, hashCache *HashCache) fs.File {
 	return &physicalFile{
 		name:        name,
 		path:        path,
@@ -32,6 +40,27 @@ func New(name string, path string, isDirectory bool, size int64, modTime time.Ti
 		size:        size,
 		modTime:     modTime,
 		mode:        mode,
+		hashCache:   hashCache,
+		links:       1,
+	}
+}
+
+// NewFromFileInfo initializes a new fs.File from
+// the os.FileInfo of the file stored in path, resolving
+// its owner, group and link count where supported.
+func NewFromFileInfo(path string, info os.FileInfo, hashCache *HashCache) fs.File {
+	owner, group, links := ownership(info)
+	return &physicalFile{
+		name:        info.Name(),
+		path:        path,
+		isDirectory: info.IsDir(),
+		size:        info.Size(),
+		modTime:     info.ModTime(),
+		mode:        info.Mode(),
+		hashCache:   hashCache,
+		owner:       owner,
+		group:       group,
+		links:       links,
 	}
 }
 
@@ -63,6 +92,21 @@ func (p physicalFile) Mode() string {
 	return p.mode.String()
 }
 
+// Owner implements fs.Owned
+func (p physicalFile) Owner() string {
+	return p.owner
+}
+
+// Group implements fs.Owned
+func (p physicalFile) Group() string {
+	return p.group
+}
+
+// Links implements fs.Owned
+func (p physicalFile) Links() int {
+	return p.links
+}
+
 func (p physicalFile) Read(startPosition int64) (io.ReadCloser, error) {
 	log.WithFields(log.Fields{"p": p}).Debug("localFS::physicalFile::Get called")
 
@@ -85,9 +129,105 @@ func (p physicalFile) Read(startPosition int64) (io.ReadCloser, error) {
 func (p physicalFile) Write() (io.WriteCloser, error) {
 	log.WithFields(log.Fields{}).Debug("localFS::physicalFile::Write called")
 
+	if p.hashCache != nil {
+		p.hashCache.Invalidate(p.FullPath())
+	}
+
 	return os.Create(p.FullPath())
 }
 
+// Hash implements fs.Hasher streaming the file
+// content. Digests are cached (if a cache is available)
+// and reused until either size or modification time change.
+func (p physicalFile) Hash(algorithm string) ([]byte, error) {
+	log.WithFields(log.Fields{"p": p, "algorithm": algorithm}).Debug("localFS::physicalFile::Hash called")
+
+	if p.isDirectory {
+		return nil, fmt.Errorf("%s is a directory", p.name)
+	}
+
+	if p.hashCache != nil {
+		if digest, ok := p.hashCache.Get(p.FullPath(), algorithm, p.size, p.modTime); ok {
+			log.WithFields(log.Fields{"p": p, "algorithm": algorithm}).Debug("localFS::physicalFile::Hash cache hit")
+			return digest, nil
+		}
+	}
+
+	if digest, ok := p.storedHash(algorithm); ok {
+		log.WithFields(log.Fields{"p": p, "algorithm": algorithm}).Debug("localFS::physicalFile::Hash found stored digest")
+		if p.hashCache != nil {
+			p.hashCache.Put(p.FullPath(), algorithm, p.size, p.modTime, digest)
+		}
+		return digest, nil
+	}
+
+	f, err := os.Open(p.FullPath())
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	digest, err := fs.HashReader(f, algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.hashCache != nil {
+		p.hashCache.Put(p.FullPath(), algorithm, p.size, p.modTime, digest)
+	}
+
+	return digest, nil
+}
+
+// StoreHash implements fs.HashStorer. The digest is saved
+// in an extended attribute of the file, along with the size and
+// modification time, so it can be trusted until the file changes.
+func (p physicalFile) StoreHash(algorithm string, digest []byte) error {
+	log.WithFields(log.Fields{"p": p, "algorithm": algorithm}).Debug("localFS::physicalFile::StoreHash called")
+
+	stat, err := os.Stat(p.FullPath())
+	if err != nil {
+		return err
+	}
+
+	if p.hashCache != nil {
+		p.hashCache.Put(p.FullPath(), algorithm, stat.Size(), stat.ModTime(), digest)
+	}
+
+	value := fmt.Sprintf("%d %d %s", stat.Size(), stat.ModTime().UnixNano(), hex.EncodeToString(digest))
+	return setXattr(p.FullPath(), xattrName(algorithm), []byte(value))
+}
+
+// storedHash returns the digest saved by StoreHash
+// if still valid
+func (p physicalFile) storedHash(algorithm string) ([]byte, bool) {
+	value, err := getXattr(p.FullPath(), xattrName(algorithm))
+	if err != nil {
+		return nil, false
+	}
+
+	var size, modTime int64
+	var digest string
+	if _, err := fmt.Sscanf(string(value), "%d %d %s", &size, &modTime, &digest); err != nil {
+		return nil, false
+	}
+
+	if size != p.size || modTime != p.modTime.UnixNano() {
+		return nil, false
+	}
+
+	decoded, err := hex.DecodeString(digest)
+	if err != nil {
+		return nil, false
+	}
+
+	return decoded, true
+}
+
+func xattrName(algorithm string) string {
+	return "user.ftpserver2." + strings.ToLower(strings.Replace(algorithm, "-", "", -1))
+}
+
 func (p physicalFile) Clone() fs.File {
 	return &physicalFile{
 		name:        p.name,
@@ -95,9 +235,18 @@ func (p physicalFile) Clone() fs.File {
 		isDirectory: p.isDirectory,
 		size:        p.size,
 		modTime:     p.modTime,
+		mode:        p.mode,
+		hashCache:   p.hashCache,
+		owner:       p.owner,
+		group:       p.group,
+		links:       p.links,
 	}
 }
 
 func (p physicalFile) Delete() error {
+	if p.hashCache != nil {
+		p.hashCache.Invalidate(p.FullPath())
+	}
+
 	return os.Remove(p.FullPath())
 }
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package physicalFile
+
+import "fmt"
+
+func setXattr(path, name string, value []byte) error {
+	return fmt.Errorf("extended attributes are not supported on this platform")
+}
+
+func getXattr(path, name string) ([]byte, error) {
+	return nil, fmt.Errorf("extended attributes are not supported on this platform")
+}
@@ -4,6 +4,7 @@
 package ftp
 
 import (
+	"crypto/rand"
 	"crypto/tls"
 	"fmt"
 	"net"
@@ -11,10 +12,16 @@ import (
 	"time"
 
 	log "github.com/sirupsen/logrus"
+	"github.com/mindflavor/ftpserver2/ftp/auditlog"
 	"github.com/mindflavor/ftpserver2/ftp/fs"
+	"github.com/mindflavor/ftpserver2/ftp/iprules"
+	"github.com/mindflavor/ftpserver2/ftp/logintracker"
+	"github.com/mindflavor/ftpserver2/ftp/metrics"
 	"github.com/mindflavor/ftpserver2/ftp/portassigner"
+	"github.com/mindflavor/ftpserver2/ftp/proxyproto"
 	"github.com/mindflavor/ftpserver2/ftp/session"
 	"github.com/mindflavor/ftpserver2/ftp/session/securableConn"
+	"github.com/mindflavor/ftpserver2/ftp/throttle"
 	"github.com/mindflavor/goserializer"
 )
 
@@ -22,18 +29,41 @@ const iNVALIDPORT = -1
 
 // Server is the FTP server structure
 type Server struct {
-	commandPort       int
-	tlsPort           int
-	connectionTimeout time.Duration
-	pa                portassigner.PortAssigner
-	listener          net.Listener
-	tlsListener       net.Listener
-	alive             bool
-	handler           serializer.Serializer
-	activeSessions    map[string]*session.Session
-	authFunction      session.AuthenticatorFunc
-	fileProvider      fs.FileProvider
-	cert              *tls.Certificate
+	commandPort          int
+	tlsPort              int
+	connectionTimeout    time.Duration
+	pa                   portassigner.PortAssigner
+	listener             net.Listener
+	tlsListener          net.Listener
+	alive                bool
+	handler              serializer.Serializer
+	activeSessions       map[string]*session.Session
+	authFunction         session.AuthenticatorFunc
+	fileProvider         fs.FileProvider
+	tlsConfig            *tls.Config
+	requireTLSResumption bool
+	clientCertMode       session.ClientCertMode
+	certAuthFunction     session.CertificateAuthenticatorFunc
+	allowRecursiveDelete bool
+	maskOwner            bool
+	listLimits           session.ListLimits
+	identityAuthFunction session.IdentityAuthenticatorFunc
+	downloadLimiter      *throttle.Limiter
+	uploadLimiter        *throttle.Limiter
+	sessionDownloadLimit int64
+	sessionUploadLimit   int64
+	maxSessions          int
+	maxSessionsPerIP     int
+	maxLoginsPerUser     int
+	userLogins           map[string]int
+	loginTracker         *logintracker.Tracker
+	ipRules              *iprules.Config
+	trustedProxies       []*net.IPNet
+	tlsPolicy            session.TLSPolicy
+	metrics              *metrics.Metrics
+	auditLog             *auditlog.Logger
+	hooks                *session.Hooks
+	registry             *session.Registry
 }
 
 // NewPlain creates a new plain (ie without explicit TLS port) FTP Server.
@@ -43,7 +73,7 @@ func NewPlain(commandPort int, cert *tls.Certificate, connectionTimeout time.Dur
 	return &Server{
 		commandPort:       commandPort,
 		tlsPort:           iNVALIDPORT,
-		cert:              cert,
+		tlsConfig:         newTLSConfig(cert),
 		connectionTimeout: connectionTimeout,
 		pa:                portassigner.New(minPASVPort, maxPASVPort),
 		alive:             true,
@@ -51,6 +81,13 @@ func NewPlain(commandPort int, cert *tls.Certificate, connectionTimeout time.Dur
 		activeSessions:    make(map[string]*session.Session),
 		authFunction:      authFunction,
 		fileProvider:      fp,
+		downloadLimiter:   throttle.NewLimiter(0),
+		uploadLimiter:     throttle.NewLimiter(0),
+		userLogins:        make(map[string]int),
+		loginTracker:      logintracker.New(logintracker.DefaultPolicy()),
+		hooks:             session.NewHooks(),
+		registry:          session.NewRegistry(),
+		listLimits:        session.DefaultListLimits(),
 	}
 }
 
@@ -60,7 +97,7 @@ func New(commandPort int, tlsPort int, cert *tls.Certificate, connectionTimeout
 	return &Server{
 		commandPort:       commandPort,
 		tlsPort:           tlsPort,
-		cert:              cert,
+		tlsConfig:         newTLSConfig(cert),
 		connectionTimeout: connectionTimeout,
 		pa:                portassigner.New(minPASVPort, maxPASVPort),
 		alive:             true,
@@ -68,6 +105,13 @@ func New(commandPort int, tlsPort int, cert *tls.Certificate, connectionTimeout
 		activeSessions:    make(map[string]*session.Session),
 		authFunction:      authFunction,
 		fileProvider:      fp,
+		downloadLimiter:   throttle.NewLimiter(0),
+		uploadLimiter:     throttle.NewLimiter(0),
+		userLogins:        make(map[string]int),
+		loginTracker:      logintracker.New(logintracker.DefaultPolicy()),
+		hooks:             session.NewHooks(),
+		registry:          session.NewRegistry(),
+		listLimits:        session.DefaultListLimits(),
 	}
 }
 
@@ -76,7 +120,7 @@ func NewTLS(tlsPort int, cert *tls.Certificate, connectionTimeout time.Duration,
 	return &Server{
 		commandPort:       iNVALIDPORT,
 		tlsPort:           tlsPort,
-		cert:              cert,
+		tlsConfig:         newTLSConfig(cert),
 		connectionTimeout: connectionTimeout,
 		pa:                portassigner.New(minPASVPort, maxPASVPort),
 		alive:             true,
@@ -84,9 +128,177 @@ func NewTLS(tlsPort int, cert *tls.Certificate, connectionTimeout time.Duration,
 		activeSessions:    make(map[string]*session.Session),
 		authFunction:      authFunction,
 		fileProvider:      fp,
+		downloadLimiter:   throttle.NewLimiter(0),
+		uploadLimiter:     throttle.NewLimiter(0),
+		userLogins:        make(map[string]int),
+		loginTracker:      logintracker.New(logintracker.DefaultPolicy()),
+		hooks:             session.NewHooks(),
+		registry:          session.NewRegistry(),
+		listLimits:        session.DefaultListLimits(),
 	}
 }
 
+// newTLSConfig creates the TLS configuration shared
+// by every session. The session ticket keys are set once
+// so each session can resume the TLS sessions issued by
+// its own control connection (see session.BindTLSConfig).
+func newTLSConfig(cert *tls.Certificate) *tls.Config {
+	if cert == nil {
+		return nil
+	}
+
+	cfg := &tls.Config{Certificates: []tls.Certificate{*cert}}
+	cfg.SetSessionTicketKeys([][32]byte{newTicketKey()})
+	return cfg
+}
+
+// newTicketKey returns a random session ticket key
+func newTicketKey() [32]byte {
+	var key [32]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// SetTLSConfig replaces the TLS configuration built from
+// the certificate passed to the constructor. Use it to set the
+// TLS versions, the cipher suites, ALPN, the client certificate
+// verification and so on. The same configuration is used by the
+// implicit TLS listener, AUTH TLS and the data connections.
+// The session ticket keys are replaced with random ones (shared by
+// every session) unless cfg has SessionTicketsDisabled.
+// It must be called before Accept.
+func (srv *Server) SetTLSConfig(cfg *tls.Config) {
+	if cfg == nil {
+		srv.tlsConfig = nil
+		return
+	}
+
+	cfg = cfg.Clone()
+	if !cfg.SessionTicketsDisabled {
+		cfg.SetSessionTicketKeys([][32]byte{newTicketKey()})
+	}
+	srv.tlsConfig = cfg
+}
+
+// SetClientCertAuth enables the client certificate (mutual
+// TLS) authentication for the sessions created from now on. f maps
+// a verified certificate to the identity of the user: with
+// session.ClientCertSufficient the certificate replaces the
+// password, with session.ClientCertRequired both are needed.
+// The TLS configuration (see SetTLSConfig) must request and verify
+// the client certificates.
+func (srv *Server) SetClientCertAuth(mode session.ClientCertMode, f session.CertificateAuthenticatorFunc) {
+	srv.clientCertMode = mode
+	srv.certAuthFunction = f
+}
+
+// SetRequireTLSResumption makes the server refuse (522) the
+// encrypted data connections that do not resume the TLS session
+// of their control connection, for the sessions created from now on.
+// This prevents data connection theft but requires a client
+// supporting TLS session resumption (most of them do).
+func (srv *Server) SetRequireTLSResumption(require bool) {
+	srv.requireTLSResumption = require
+}
+
+// SetAllowRecursiveDelete enables the RMDA command (remove
+// a directory tree) for the sessions created from now on.
+// It's disabled by default and requires a FileProvider implementing
+// fs.RecursiveRemover.
+func (srv *Server) SetAllowRecursiveDelete(allow bool) {
+	srv.allowRecursiveDelete = allow
+}
+
+// SetMaskOwner makes the sessions created from now on
+// show the logged in username as owner and group of every
+// file in LIST, MLSD and MLST instead of the real ones.
+func (srv *Server) SetMaskOwner(mask bool) {
+	srv.maskOwner = mask
+}
+
+// SetListLimits sets the LIST and NLST limits (recursion
+// depth and number of entries) of the sessions created from
+// now on. The default is session.DefaultListLimits().
+func (srv *Server) SetListLimits(l session.ListLimits) {
+	srv.listLimits = l
+}
+
+// SetAuditLog sets the log receiving one record per
+// completed or aborted transfer of the sessions created
+// from now on. Pass nil to disable it.
+func (srv *Server) SetAuditLog(l *auditlog.Logger) {
+	srv.auditLog = l
+}
+
+// SetProxyProtocol enables the PROXY protocol (v1 and v2)
+// on the plain and implicit TLS listeners. Connections from
+// the trusted networks must start with a PROXY header and the
+// client address it carries is used in place of the proxy one
+// (for logging, session limits and IP rules). Connections
+// from other addresses are handled as usual.
+// It must be called before Accept.
+func (srv *Server) SetProxyProtocol(trusted []*net.IPNet) {
+	srv.trustedProxies = trusted
+}
+
+// wrapProxyProtocol returns listener wrapped by a
+// proxyproto.Listener if the PROXY protocol is enabled
+func (srv *Server) wrapProxyProtocol(listener net.Listener) net.Listener {
+	if len(srv.trustedProxies) == 0 {
+		return listener
+	}
+
+	log.WithFields(log.Fields{
+		"listener.Addr().String()": listener.Addr().String(),
+		"trustedProxies":           srv.trustedProxies,
+	}).Info("Server::Accept PROXY protocol enabled")
+
+	return proxyproto.NewListener(listener, srv.trustedProxies, proxyproto.DefaultTimeout)
+}
+
+// SetTLSPolicy sets the policy forcing the clients to
+// encrypt the control connection before logging in and/or
+// the data connections (PROT P), for the sessions created
+// from now on. It makes sense only with a certificate.
+func (srv *Server) SetTLSPolicy(p session.TLSPolicy) {
+	srv.tlsPolicy = p
+}
+
+// SetIdentityAuthenticator replaces the AuthenticatorFunc
+// with an IdentityAuthenticatorFunc for the sessions created
+// from now on. Use it to provide per user settings (for example
+// bandwidth limits with identity.BandwidthLimited).
+func (srv *Server) SetIdentityAuthenticator(f session.IdentityAuthenticatorFunc) {
+	srv.identityAuthFunction = f
+}
+
+// SetBandwidthLimits sets the global transfer rate limits,
+// shared between all the sessions, in bytes per second.
+// Pass 0 for unlimited. It can be called at any time.
+func (srv *Server) SetBandwidthLimits(download, upload int64) {
+	srv.downloadLimiter.SetRate(download)
+	srv.uploadLimiter.SetRate(upload)
+}
+
+// SetSessionBandwidthLimits sets the transfer rate limits
+// of each session in bytes per second. Pass 0 for unlimited.
+// It can be called at any time: the active sessions are updated too.
+// Users whose identity implements identity.BandwidthLimited
+// are not affected.
+func (srv *Server) SetSessionBandwidthLimits(download, upload int64) {
+	srv.handler.Serialize(func() interface{} {
+		srv.sessionDownloadLimit = download
+		srv.sessionUploadLimit = upload
+
+		for _, s := range srv.activeSessions {
+			s.SetBandwidthLimits(download, upload)
+		}
+		return nil
+	})
+}
+
 // Accept starts the FTP server
 // the server lives in a separate
 // go func.
@@ -111,7 +323,7 @@ func (srv *Server) Accept() error {
 				return err
 			}
 
-			srv.listener = listener
+			srv.listener = srv.wrapProxyProtocol(listener)
 		}
 
 		log.WithFields(log.Fields{
@@ -121,18 +333,18 @@ func (srv *Server) Accept() error {
 
 	// explicit TLS port (std 990)
 	if srv.tlsPort != iNVALIDPORT {
-		if srv.cert == nil {
-			panic("cannot initialize a TLS FTP Server with nil certificate")
+		if srv.tlsConfig == nil {
+			panic("cannot initialize a TLS FTP Server without certificate or TLS configuration")
 		}
 
-		sslConfig := tls.Config{Certificates: []tls.Certificate{*srv.cert}}
-
-		tlsListener, err := tls.Listen("tcp", fmt.Sprintf(":%d", srv.tlsPort), &sslConfig)
+		tlsListener, err := net.Listen("tcp", fmt.Sprintf(":%d", srv.tlsPort))
 		if err != nil {
 			return err
 		}
 
-		srv.tlsListener = tlsListener
+		// the TLS handshake happens in recordSession
+		// with the configuration bound to the session
+		srv.tlsListener = srv.wrapProxyProtocol(tlsListener)
 
 		log.WithFields(log.Fields{
 			"commandPort": srv.tlsPort,
@@ -140,121 +352,153 @@ func (srv *Server) Accept() error {
 	}
 
 	if srv.listener != nil {
-		go func() {
-			defer srv.listener.Close()
-
-			for srv.alive {
-				conn, err := srv.listener.Accept()
-				if err != nil {
-					log.WithField("error", err).Fatalf("Error in Accept")
-					return
-				}
-
-				if !srv.alive {
-					conn.Close()
-					return
-				}
-
-				log.WithFields(log.Fields{
-					"conn.LocalAddr().Network()":  conn.LocalAddr().Network(),
-					"conn.LocalAddr().String()":   conn.LocalAddr().String(),
-					"conn.RemoteAddr().Network()": conn.RemoteAddr().Network(),
-					"conn.RemoteAddr().String()":  conn.RemoteAddr().String(),
-				}).Info("Server::Accept accepted")
-
-				session := srv.recordSession(conn, nil)
-
-				go func() {
-					defer srv.releaseSession(conn)
-
-					session.Handle() // this is blocking
-
-					log.WithFields(log.Fields{
-						"session": session,
-					}).Info("Server::Accept session terminated")
-				}()
-			}
-		}()
+		go srv.serve(srv.listener, false)
 	}
 
 	if srv.tlsListener != nil {
-		go func() {
-			defer srv.tlsListener.Close()
-
-			for srv.alive {
-				conn, err := srv.tlsListener.Accept()
-				if err != nil {
-					log.WithField("error", err).Fatalf("Error in TLS Accept")
-					return
-				}
-
-				if !srv.alive {
-					conn.Close()
-					return
-				}
-
-				log.WithFields(log.Fields{
-					"conn.LocalAddr().Network()":  conn.LocalAddr().Network(),
-					"conn.LocalAddr().String()":   conn.LocalAddr().String(),
-					"conn.RemoteAddr().Network()": conn.RemoteAddr().Network(),
-					"conn.RemoteAddr().String()":  conn.RemoteAddr().String(),
-				}).Info("Server::Accept accepted")
-
-				session := srv.recordSession(nil, conn)
-
-				go func() {
-					defer srv.releaseSession(conn)
-
-					session.Handle() // this is blocking
-
-					log.WithFields(log.Fields{
-						"session": session,
-					}).Info("Server::Accept session terminated")
-				}()
-			}
-		}()
+		go srv.serve(srv.tlsListener, true)
 	}
 
 	return nil
 }
 
-func (srv *Server) recordSession(conn net.Conn, secure net.Conn) *session.Session {
-	if conn != nil {
-		log.WithFields(log.Fields{
-			"Server":                      srv,
-			"conn.LocalAddr().Network()":  conn.LocalAddr().Network(),
-			"conn.LocalAddr().String()":   conn.LocalAddr().String(),
-			"conn.RemoteAddr().Network()": conn.RemoteAddr().Network(),
-			"conn.RemoteAddr().String()":  conn.RemoteAddr().String(),
-		}).Debug("Server::recordConnection called")
-
-		sessionInt := srv.handler.Serialize(func() interface{} {
-			s := session.New(securableConn.New(conn, nil, srv.cert), srv.cert, srv.connectionTimeout, srv.pa, srv.authFunction, srv.fileProvider.Clone())
-			srv.activeSessions[conn.RemoteAddr().String()] = s
-			return s
-		})
-
-		return sessionInt.(*session.Session)
+// serve is the accept loop of a listener.
+// secure must be true for the implicit TLS listener.
+func (srv *Server) serve(listener net.Listener, secure bool) {
+	defer listener.Close()
+
+	for srv.alive {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.WithFields(log.Fields{"error": err, "secure": secure}).Fatalf("Error in Accept")
+			return
+		}
+
+		if !srv.alive {
+			conn.Close()
+			return
+		}
+
+		// with the PROXY protocol RemoteAddr waits for the
+		// header so the connection is handled in its own go func
+		go srv.handleConn(conn, secure)
+	}
+}
+
+// handleConn records the session of an accepted
+// connection (or refuses it) and runs it
+func (srv *Server) handleConn(conn net.Conn, secure bool) {
+	if err := proxyHeaderError(conn); err != nil {
+		log.WithFields(log.Fields{"err": err, "secure": secure}).Warn("Server::Accept invalid PROXY header, connection dropped")
+		conn.Close()
+		return
 	}
-	if secure != nil {
+
+	log.WithFields(log.Fields{
+		"conn.LocalAddr().Network()":  conn.LocalAddr().Network(),
+		"conn.LocalAddr().String()":   conn.LocalAddr().String(),
+		"conn.RemoteAddr().Network()": conn.RemoteAddr().Network(),
+		"conn.RemoteAddr().String()":  conn.RemoteAddr().String(),
+		"secure":                      secure,
+	}).Info("Server::Accept accepted")
+
+	session, err := srv.recordSession(conn, secure)
+	if err != nil {
 		log.WithFields(log.Fields{
-			"Server":                      srv,
-			"conn.LocalAddr().Network()":  secure.LocalAddr().Network(),
-			"conn.LocalAddr().String()":   secure.LocalAddr().String(),
-			"conn.RemoteAddr().Network()": secure.RemoteAddr().Network(),
-			"conn.RemoteAddr().String()":  secure.RemoteAddr().String(),
-		}).Debug("Server::recordConnection TLS called")
-
-		sessionInt := srv.handler.Serialize(func() interface{} {
-			s := session.New(securableConn.New(nil, secure.(*tls.Conn), srv.cert), srv.cert, srv.connectionTimeout, srv.pa, srv.authFunction, srv.fileProvider.Clone())
-			srv.activeSessions[secure.RemoteAddr().String()] = s
-			return s
-		})
-
-		return sessionInt.(*session.Session)
+			"conn.RemoteAddr().String()": conn.RemoteAddr().String(),
+			"err":                        err,
+		}).Warn("Server::Accept connection refused")
+
+		if secure {
+			// the implicit TLS handshake has not happened yet
+			conn = tls.Server(conn, srv.tlsConfig)
+		}
+		srv.refuse(conn, err)
+		return
+	}
+
+	defer srv.releaseSession(conn)
+
+	session.Handle() // this is blocking
+
+	log.WithFields(log.Fields{
+		"session": session,
+	}).Info("Server::Accept session terminated")
+}
+
+// proxyHeaderError returns the PROXY header
+// parsing error of conn, if any
+func proxyHeaderError(conn net.Conn) error {
+	if proxyConn, ok := conn.(*proxyproto.Conn); ok {
+		return proxyConn.Err()
+	}
+
+	return nil
+}
+
+// refuse sends the 421 reply and closes the connection
+func (srv *Server) refuse(conn net.Conn, reason error) {
+	defer conn.Close()
+
+	conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	fmt.Fprintf(conn, "421 Service not available, %s.\r\n", reason)
+}
+
+func (srv *Server) recordSession(conn net.Conn, secure bool) (*session.Session, error) {
+	log.WithFields(log.Fields{
+		"Server":                      srv,
+		"conn.LocalAddr().Network()":  conn.LocalAddr().Network(),
+		"conn.LocalAddr().String()":   conn.LocalAddr().String(),
+		"conn.RemoteAddr().Network()": conn.RemoteAddr().Network(),
+		"conn.RemoteAddr().String()":  conn.RemoteAddr().String(),
+		"secure":                      secure,
+	}).Debug("Server::recordConnection called")
+
+	sessionInt := srv.handler.Serialize(func() interface{} {
+		if err := srv.checkSessionLimits(conn.RemoteAddr()); err != nil {
+			return err
+		}
+
+		tlsConfig := session.BindTLSConfig(srv.tlsConfig)
+
+		var s *session.Session
+		if secure {
+			s = srv.newSession(securableConn.New(nil, tls.Server(conn, tlsConfig), tlsConfig), tlsConfig)
+		} else {
+			s = srv.newSession(securableConn.New(conn, nil, tlsConfig), tlsConfig)
+		}
+		srv.activeSessions[conn.RemoteAddr().String()] = s
+		return s
+	})
+
+	if err, ok := sessionInt.(error); ok {
+		return nil, err
 	}
 
-	panic("recordSession with no session called!")
+	return sessionInt.(*session.Session), nil
+}
+
+// newSession creates a session.Session configured
+// with the server settings
+func (srv *Server) newSession(conn securableConn.Conn, tlsConfig *tls.Config) *session.Session {
+	s := session.New(conn, tlsConfig, srv.connectionTimeout, srv.pa, srv.authFunction, metrics.WrapFileProvider(srv.fileProvider.Clone(), srv.metrics))
+	s.SetAllowRecursiveDelete(srv.allowRecursiveDelete)
+	s.SetMaskOwner(srv.maskOwner)
+	s.SetListLimits(srv.listLimits)
+	s.SetIdentityAuthenticator(srv.identityAuthFunction)
+	s.SetGlobalBandwidthLimiters(srv.downloadLimiter, srv.uploadLimiter)
+	s.SetBandwidthLimits(srv.sessionDownloadLimit, srv.sessionUploadLimit)
+	s.SetLoginCounter((*loginCounter)(srv))
+	s.SetLoginTracker(srv.loginTracker)
+	s.SetIPRules((*userIPRules)(srv))
+	s.SetTLSPolicy(srv.tlsPolicy)
+	s.SetRequireTLSResumption(srv.requireTLSResumption)
+	s.SetClientCertAuth(srv.clientCertMode, srv.certAuthFunction)
+	s.SetMetrics(srv.metrics)
+	s.SetAuditLog(srv.auditLog)
+	s.SetHooks(srv.hooks)
+	s.SetRegistry(srv.registry)
+	return s
 }
 
 func (srv *Server) releaseSession(conn net.Conn) {
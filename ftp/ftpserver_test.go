@@ -1,9 +1,12 @@
 package ftp
 
 import (
+	"net"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/mindflavor/ftpserver2/ftp/iprules"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -17,3 +20,59 @@ func Test(t *testing.T) {
 
 	assert.NotNil(t, ftp)
 }
+
+func TestSessionLimits(t *testing.T) {
+	srv := NewPlain(21, nil, time.Minute, 5000, 5100, nil, nil)
+
+	addr := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1000}
+	assert.NoError(t, srv.checkSessionLimits(addr))
+
+	srv.activeSessions["10.0.0.1:999"] = nil
+	srv.activeSessions["10.0.0.2:999"] = nil
+
+	srv.SetMaxSessionsPerIP(1)
+	assert.Error(t, srv.checkSessionLimits(addr))
+	assert.NoError(t, srv.checkSessionLimits(&net.TCPAddr{IP: net.ParseIP("10.0.0.3"), Port: 1000}))
+
+	srv.SetMaxSessionsPerIP(0)
+	srv.SetMaxSessions(2)
+	assert.Error(t, srv.checkSessionLimits(addr))
+
+	assert.Equal(t, 2, srv.ActiveSessions())
+	assert.Equal(t, map[string]int{"10.0.0.1": 1, "10.0.0.2": 1}, srv.ActiveSessionsByIP())
+}
+
+func TestLoginLimits(t *testing.T) {
+	srv := NewPlain(21, nil, time.Minute, 5000, 5100, nil, nil)
+	srv.SetMaxLoginsPerUser(1)
+
+	lc := (*loginCounter)(srv)
+
+	assert.True(t, lc.Acquire("user"))
+	assert.False(t, lc.Acquire("user"))
+	assert.True(t, lc.Acquire("other"))
+
+	lc.Release("user")
+	assert.True(t, lc.Acquire("user"))
+	assert.Equal(t, map[string]int{"user": 1, "other": 1}, srv.ActiveLogins())
+}
+
+func TestIPRules(t *testing.T) {
+	srv := NewPlain(21, nil, time.Minute, 5000, 5100, nil, nil)
+
+	cfg, err := iprules.Parse(strings.NewReader("allow 10.0.0.0/8\nuser partner allow 10.1.0.0/16\n"))
+	assert.NoError(t, err)
+	srv.SetIPRules(cfg)
+
+	assert.NoError(t, srv.checkSessionLimits(&net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1000}))
+	assert.Error(t, srv.checkSessionLimits(&net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 1000}))
+
+	r := (*userIPRules)(srv)
+	assert.True(t, r.UserAllowed("partner", net.ParseIP("10.1.2.3")))
+	assert.False(t, r.UserAllowed("partner", net.ParseIP("10.0.0.1")))
+	assert.True(t, r.UserAllowed("other", net.ParseIP("10.0.0.1")))
+
+	srv.SetIPRules(nil)
+	assert.NoError(t, srv.checkSessionLimits(&net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 1000}))
+	assert.True(t, r.UserAllowed("partner", net.ParseIP("10.0.0.1")))
+}
@@ -0,0 +1,56 @@
+package ftp
+
+import "github.com/mindflavor/ftpserver2/ftp/session"
+
+// OnLogin registers f, called when a user logs in.
+// A HookSync handler can refuse the login.
+func (srv *Server) OnLogin(mode session.HookMode, f session.EventHandler) {
+	srv.hooks.Add(session.EventLogin, mode, f)
+}
+
+// OnLogout registers f, called when the
+// session of a logged in user ends
+func (srv *Server) OnLogout(mode session.HookMode, f session.EventHandler) {
+	srv.hooks.Add(session.EventLogout, mode, f)
+}
+
+// OnUploadComplete registers f, called when a STOR
+// completes. A HookSync handler can refuse the
+// file, which is then removed.
+func (srv *Server) OnUploadComplete(mode session.HookMode, f session.EventHandler) {
+	srv.hooks.Add(session.EventUploadComplete, mode, f)
+}
+
+// OnDownloadComplete registers f,
+// called when a RETR completes
+func (srv *Server) OnDownloadComplete(mode session.HookMode, f session.EventHandler) {
+	srv.hooks.Add(session.EventDownloadComplete, mode, f)
+}
+
+// OnDelete registers f, called when a file
+// is deleted (DELE). A HookSync handler can
+// prevent the deletion.
+func (srv *Server) OnDelete(mode session.HookMode, f session.EventHandler) {
+	srv.hooks.Add(session.EventDelete, mode, f)
+}
+
+// OnRename registers f, called when a file or
+// directory is renamed (RNFR and RNTO). A HookSync
+// handler can prevent the rename.
+func (srv *Server) OnRename(mode session.HookMode, f session.EventHandler) {
+	srv.hooks.Add(session.EventRename, mode, f)
+}
+
+// OnMkdir registers f, called when a directory is
+// created (MKD). A HookSync handler can prevent
+// the creation.
+func (srv *Server) OnMkdir(mode session.HookMode, f session.EventHandler) {
+	srv.hooks.Add(session.EventMkdir, mode, f)
+}
+
+// OnRmdir registers f, called when a directory is
+// removed (RMD and RMDA). A HookSync handler can
+// prevent the removal.
+func (srv *Server) OnRmdir(mode session.HookMode, f session.EventHandler) {
+	srv.hooks.Add(session.EventRmdir, mode, f)
+}
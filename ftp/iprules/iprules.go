@@ -0,0 +1,161 @@
+// Package iprules implements CIDR based
+// allow/deny lists, both server wide and per user.
+package iprules
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+// Rules is an allow/deny list. An IP is allowed
+// if it does not match any Deny entry and either the
+// Allow list is empty or the IP matches at least one entry.
+type Rules struct {
+	Allow []*net.IPNet
+	Deny  []*net.IPNet
+}
+
+// Allowed returns true if ip passes the rules.
+// A nil Rules allows everything.
+func (r *Rules) Allowed(ip net.IP) bool {
+	if r == nil {
+		return true
+	}
+
+	if ip == nil {
+		return false
+	}
+
+	for _, n := range r.Deny {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+
+	if len(r.Allow) == 0 {
+		return true
+	}
+
+	for _, n := range r.Allow {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Config is the complete set of rules: the server
+// wide ones (checked when accepting a connection) and the
+// per user ones (checked at login).
+type Config struct {
+	Server *Rules
+	Users  map[string]*Rules
+}
+
+// User returns the rules of the specified user
+// (nil if there are none)
+func (c *Config) User(username string) *Rules {
+	if c == nil {
+		return nil
+	}
+	return c.Users[username]
+}
+
+// ParseCIDR parses either a CIDR (10.0.0.0/8)
+// or a single IP address (10.0.0.1)
+func ParseCIDR(s string) (*net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address %s", s)
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+	}
+
+	_, n, err := net.ParseCIDR(s)
+	return n, err
+}
+
+// Parse reads a rules configuration. Each line is either:
+//
+//	allow <cidr> [<cidr>...]
+//	deny <cidr> [<cidr>...]
+//	user <username> allow|deny <cidr> [<cidr>...]
+//
+// Empty lines and lines starting with # are ignored.
+func Parse(r io.Reader) (*Config, error) {
+	cfg := &Config{
+		Server: &Rules{},
+		Users:  make(map[string]*Rules),
+	}
+
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		toks := strings.Fields(scanner.Text())
+		if len(toks) == 0 || strings.HasPrefix(toks[0], "#") {
+			continue
+		}
+
+		rules := cfg.Server
+		if strings.ToLower(toks[0]) == "user" {
+			if len(toks) < 2 {
+				return nil, fmt.Errorf("line %d: username expected", lineNo)
+			}
+			rules = cfg.Users[toks[1]]
+			if rules == nil {
+				rules = &Rules{}
+				cfg.Users[toks[1]] = rules
+			}
+			toks = toks[2:]
+		}
+
+		if len(toks) < 2 {
+			return nil, fmt.Errorf("line %d: allow or deny followed by at least a CIDR expected", lineNo)
+		}
+
+		var nets []*net.IPNet
+		for _, s := range toks[1:] {
+			n, err := ParseCIDR(s)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", lineNo, err)
+			}
+			nets = append(nets, n)
+		}
+
+		switch strings.ToLower(toks[0]) {
+		case "allow":
+			rules.Allow = append(rules.Allow, nets...)
+		case "deny":
+			rules.Deny = append(rules.Deny, nets...)
+		default:
+			return nil, fmt.Errorf("line %d: unknown directive %s", lineNo, toks[0])
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// LoadFile parses the specified rules file
+func LoadFile(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Parse(f)
+}
@@ -0,0 +1,47 @@
+package iprules
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const sample = `
+# partners only
+allow 10.0.0.0/8 192.168.1.1
+deny 10.0.0.66
+
+user alice allow 192.168.1.0/24
+user bob deny 0.0.0.0/0 ::/0
+`
+
+func TestParse(t *testing.T) {
+	cfg, err := Parse(strings.NewReader(sample))
+	assert.NoError(t, err)
+
+	assert.True(t, cfg.Server.Allowed(net.ParseIP("10.1.2.3")))
+	assert.True(t, cfg.Server.Allowed(net.ParseIP("192.168.1.1")))
+	assert.False(t, cfg.Server.Allowed(net.ParseIP("192.168.1.2")))
+	assert.False(t, cfg.Server.Allowed(net.ParseIP("10.0.0.66")))
+
+	assert.True(t, cfg.User("alice").Allowed(net.ParseIP("192.168.1.200")))
+	assert.False(t, cfg.User("alice").Allowed(net.ParseIP("10.1.2.3")))
+	assert.False(t, cfg.User("bob").Allowed(net.ParseIP("10.1.2.3")))
+	assert.False(t, cfg.User("bob").Allowed(net.ParseIP("::1")))
+
+	// no rules, no restrictions
+	assert.True(t, cfg.User("carol").Allowed(net.ParseIP("8.8.8.8")))
+}
+
+func TestParseErrors(t *testing.T) {
+	_, err := Parse(strings.NewReader("allow 10.0.0.0/33"))
+	assert.Error(t, err)
+
+	_, err = Parse(strings.NewReader("permit 10.0.0.0/8"))
+	assert.Error(t, err)
+
+	_, err = Parse(strings.NewReader("user alice"))
+	assert.Error(t, err)
+}
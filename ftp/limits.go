@@ -0,0 +1,191 @@
+package ftp
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/mindflavor/ftpserver2/ftp/iprules"
+	"github.com/mindflavor/ftpserver2/ftp/logintracker"
+)
+
+// SetMaxSessions sets the maximum number of
+// concurrent sessions (0 means unlimited). Connections
+// over the limit receive a 421 reply and are closed.
+func (srv *Server) SetMaxSessions(max int) {
+	srv.handler.Serialize(func() interface{} {
+		srv.maxSessions = max
+		return nil
+	})
+}
+
+// SetMaxSessionsPerIP sets the maximum number of
+// concurrent sessions from the same remote IP (0 means unlimited).
+// Connections over the limit receive a 421 reply and are closed.
+func (srv *Server) SetMaxSessionsPerIP(max int) {
+	srv.handler.Serialize(func() interface{} {
+		srv.maxSessionsPerIP = max
+		return nil
+	})
+}
+
+// SetMaxLoginsPerUser sets the maximum number of
+// concurrent authenticated sessions of the same user
+// (0 means unlimited). A PASS over the limit receives a
+// 421 reply and the connection is closed.
+func (srv *Server) SetMaxLoginsPerUser(max int) {
+	srv.handler.Serialize(func() interface{} {
+		srv.maxLoginsPerUser = max
+		return nil
+	})
+}
+
+// ActiveSessions returns the number of connected sessions
+func (srv *Server) ActiveSessions() int {
+	return srv.handler.Serialize(func() interface{} {
+		return len(srv.activeSessions)
+	}).(int)
+}
+
+// ActiveSessionsByIP returns the number of connected
+// sessions for each remote IP
+func (srv *Server) ActiveSessionsByIP() map[string]int {
+	return srv.handler.Serialize(func() interface{} {
+		counts := make(map[string]int)
+		for addr := range srv.activeSessions {
+			counts[hostOf(addr)]++
+		}
+		return counts
+	}).(map[string]int)
+}
+
+// ActiveLogins returns the number of authenticated
+// sessions for each user
+func (srv *Server) ActiveLogins() map[string]int {
+	return srv.handler.Serialize(func() interface{} {
+		counts := make(map[string]int)
+		for user, count := range srv.userLogins {
+			counts[user] = count
+		}
+		return counts
+	}).(map[string]int)
+}
+
+// SetLoginPolicy sets the brute force protection policy
+// (failed login delays, attempts per connection and bans).
+// It can be called at any time.
+func (srv *Server) SetLoginPolicy(policy logintracker.Policy) {
+	srv.loginTracker.SetPolicy(policy)
+}
+
+// Bans returns the IPs and usernames currently banned
+// because of too many failed logins
+func (srv *Server) Bans() []logintracker.Ban {
+	return srv.loginTracker.Bans()
+}
+
+// ClearBan lifts the ban of the specified kind
+// (logintracker.BanIP or logintracker.BanUser) and value.
+// It returns false if there was no such ban.
+func (srv *Server) ClearBan(kind, value string) bool {
+	return srv.loginTracker.Clear(kind, value)
+}
+
+// ClearBans lifts every ban
+func (srv *Server) ClearBans() {
+	srv.loginTracker.ClearAll()
+}
+
+// SetIPRules sets the IP allow/deny rules. The server
+// wide rules are checked when a connection is accepted,
+// the per user ones after a successful PASS. It can be
+// called at any time to reload the rules: the new ones
+// apply to the connections and logins from now on.
+// Pass nil to remove every restriction.
+func (srv *Server) SetIPRules(cfg *iprules.Config) {
+	srv.handler.Serialize(func() interface{} {
+		srv.ipRules = cfg
+		return nil
+	})
+}
+
+// checkSessionLimits returns an error if a new
+// session from addr would exceed the limits or
+// comes from a banned or not allowed IP.
+// Must be called by the srv.handler serializer.
+func (srv *Server) checkSessionLimits(addr net.Addr) error {
+	if srv.ipRules != nil && !srv.ipRules.Server.Allowed(net.ParseIP(hostOf(addr.String()))) {
+		return fmt.Errorf("connections from %s are not allowed", hostOf(addr.String()))
+	}
+
+	if srv.loginTracker.IsIPBanned(hostOf(addr.String())) {
+		return fmt.Errorf("too many failed logins from %s", hostOf(addr.String()))
+	}
+
+	if srv.maxSessions > 0 && len(srv.activeSessions) >= srv.maxSessions {
+		return fmt.Errorf("too many connections")
+	}
+
+	if srv.maxSessionsPerIP > 0 {
+		ip := hostOf(addr.String())
+		count := 0
+		for a := range srv.activeSessions {
+			if hostOf(a) == ip {
+				count++
+			}
+		}
+		if count >= srv.maxSessionsPerIP {
+			return fmt.Errorf("too many connections from %s", ip)
+		}
+	}
+
+	return nil
+}
+
+// hostOf strips the port from an address
+func hostOf(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// loginCounter implements session.LoginCounter
+// enforcing the concurrent logins per user limit
+type loginCounter Server
+
+func (lc *loginCounter) Acquire(username string) bool {
+	srv := (*Server)(lc)
+
+	return srv.handler.Serialize(func() interface{} {
+		if srv.maxLoginsPerUser > 0 && srv.userLogins[username] >= srv.maxLoginsPerUser {
+			return false
+		}
+		srv.userLogins[username]++
+		return true
+	}).(bool)
+}
+
+func (lc *loginCounter) Release(username string) {
+	srv := (*Server)(lc)
+
+	srv.handler.Serialize(func() interface{} {
+		srv.userLogins[username]--
+		if srv.userLogins[username] <= 0 {
+			delete(srv.userLogins, username)
+		}
+		return nil
+	})
+}
+
+// userIPRules implements session.IPRules
+// with the per user rules of srv.ipRules
+type userIPRules Server
+
+func (r *userIPRules) UserAllowed(username string, ip net.IP) bool {
+	srv := (*Server)(r)
+
+	return srv.handler.Serialize(func() interface{} {
+		return srv.ipRules.User(username).Allowed(ip)
+	}).(bool)
+}
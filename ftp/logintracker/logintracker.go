@@ -0,0 +1,267 @@
+// Package logintracker implements the brute force
+// protection of the FTP Server: it keeps track of the failed
+// logins of every remote IP and username and bans them
+// temporarily when they fail too often.
+package logintracker
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/mindflavor/goserializer"
+)
+
+// Ban kinds
+const (
+	BanIP   = "ip"
+	BanUser = "user"
+)
+
+// Policy describes how failed logins are handled
+type Policy struct {
+	// MaxAttemptsPerConnection is the number of failed PASS
+	// after which the connection is closed (0 means unlimited)
+	MaxAttemptsPerConnection int
+	// FailureDelay is the delay before replying to the first
+	// failed PASS of a connection. It doubles at each subsequent
+	// failure up to MaxFailureDelay. 0 disables the delay.
+	FailureDelay    time.Duration
+	MaxFailureDelay time.Duration
+	// MaxFailures is the number of failures in Window after
+	// which the remote IP (or the username) is banned for
+	// BanDuration. 0 disables the bans.
+	MaxFailures int
+	Window      time.Duration
+	BanDuration time.Duration
+}
+
+// DefaultPolicy returns the default Policy: increasing
+// delay starting from one second, at most 5 attempts per
+// connection and no bans
+func DefaultPolicy() Policy {
+	return Policy{
+		MaxAttemptsPerConnection: 5,
+		FailureDelay:             time.Second,
+		MaxFailureDelay:          8 * time.Second,
+		MaxFailures:              0,
+		Window:                   10 * time.Minute,
+		BanDuration:              30 * time.Minute,
+	}
+}
+
+// Ban is a temporary ban of a remote IP or username
+type Ban struct {
+	Kind  string
+	Value string
+	Until time.Time
+}
+
+func (b Ban) String() string {
+	return fmt.Sprintf("{%s %s until %s}", b.Kind, b.Value, b.Until.Format(time.RFC3339))
+}
+
+type key struct {
+	kind  string
+	value string
+}
+
+// Tracker records the failed logins. It's safe
+// to share a Tracker between sessions.
+type Tracker struct {
+	policy   Policy
+	failures map[key][]time.Time
+	bans     map[key]time.Time
+	handler  serializer.Serializer
+	// nextSweep is when Failure
+	// drops the stale entries again
+	nextSweep time.Time
+}
+
+// New creates a new Tracker with the specified policy
+func New(policy Policy) *Tracker {
+	return &Tracker{
+		policy:   policy,
+		failures: make(map[key][]time.Time),
+		bans:     make(map[key]time.Time),
+		handler:  serializer.New(),
+	}
+}
+
+// Policy returns the current policy
+func (t *Tracker) Policy() Policy {
+	return t.handler.Serialize(func() interface{} {
+		return t.policy
+	}).(Policy)
+}
+
+// SetPolicy replaces the policy. The bans already
+// in place are not affected.
+func (t *Tracker) SetPolicy(policy Policy) {
+	t.handler.Serialize(func() interface{} {
+		t.policy = policy
+		return nil
+	})
+}
+
+// Delay returns how long to wait before
+// replying to the attempt-th failed login of a connection
+func (t *Tracker) Delay(attempt int) time.Duration {
+	p := t.Policy()
+
+	if p.FailureDelay <= 0 || attempt < 1 {
+		return 0
+	}
+
+	d := p.FailureDelay
+	for i := 1; i < attempt; i++ {
+		d *= 2
+		if p.MaxFailureDelay > 0 && d >= p.MaxFailureDelay {
+			return p.MaxFailureDelay
+		}
+	}
+
+	return d
+}
+
+// TooManyAttempts returns true if a connection with
+// attempts failed logins must be closed
+func (t *Tracker) TooManyAttempts(attempts int) bool {
+	p := t.Policy()
+	return p.MaxAttemptsPerConnection > 0 && attempts >= p.MaxAttemptsPerConnection
+}
+
+// Failure records a failed login of username from ip.
+// The IP and the username get banned if they exceed
+// the failures allowed by the policy.
+func (t *Tracker) Failure(ip, username string) {
+	t.handler.Serialize(func() interface{} {
+		now := time.Now()
+		t.sweep(now)
+		t.recordFailure(key{kind: BanIP, value: ip}, now)
+		if username != "" {
+			t.recordFailure(key{kind: BanUser, value: username}, now)
+		}
+		return nil
+	})
+}
+
+// sweep drops the failures outside the window and the
+// expired bans, at most once per window, so the keys never
+// seen again do not stay in the maps. It must be called
+// by the handler serializer.
+func (t *Tracker) sweep(now time.Time) {
+	if now.Before(t.nextSweep) {
+		return
+	}
+	t.nextSweep = now.Add(t.policy.Window)
+
+	for k, failures := range t.failures {
+		if len(failures) == 0 || now.Sub(failures[len(failures)-1]) >= t.policy.Window {
+			delete(t.failures, k)
+		}
+	}
+
+	for k, until := range t.bans {
+		if now.After(until) {
+			delete(t.bans, k)
+		}
+	}
+}
+
+// recordFailure must be called by the handler serializer
+func (t *Tracker) recordFailure(k key, now time.Time) {
+	if t.policy.MaxFailures <= 0 {
+		return
+	}
+
+	// keep only the failures in the window
+	var recent []time.Time
+	for _, f := range t.failures[k] {
+		if now.Sub(f) < t.policy.Window {
+			recent = append(recent, f)
+		}
+	}
+	recent = append(recent, now)
+
+	if len(recent) >= t.policy.MaxFailures {
+		log.WithFields(log.Fields{"kind": k.kind, "value": k.value, "failures": len(recent), "banDuration": t.policy.BanDuration}).Warn("logintracker::Tracker::recordFailure banning")
+		t.bans[k] = now.Add(t.policy.BanDuration)
+		delete(t.failures, k)
+		return
+	}
+
+	t.failures[k] = recent
+}
+
+// Success forgets the failures of the username
+func (t *Tracker) Success(ip, username string) {
+	t.handler.Serialize(func() interface{} {
+		delete(t.failures, key{kind: BanUser, value: username})
+		return nil
+	})
+}
+
+// IsIPBanned returns true if ip is banned
+func (t *Tracker) IsIPBanned(ip string) bool {
+	return t.isBanned(key{kind: BanIP, value: ip})
+}
+
+// IsUserBanned returns true if username is banned
+func (t *Tracker) IsUserBanned(username string) bool {
+	return t.isBanned(key{kind: BanUser, value: username})
+}
+
+func (t *Tracker) isBanned(k key) bool {
+	return t.handler.Serialize(func() interface{} {
+		until, ok := t.bans[k]
+		if !ok {
+			return false
+		}
+		if time.Now().After(until) {
+			delete(t.bans, k)
+			return false
+		}
+		return true
+	}).(bool)
+}
+
+// Bans returns the active bans sorted by expiration
+func (t *Tracker) Bans() []Ban {
+	return t.handler.Serialize(func() interface{} {
+		now := time.Now()
+		bans := []Ban{}
+		for k, until := range t.bans {
+			if now.After(until) {
+				delete(t.bans, k)
+				continue
+			}
+			bans = append(bans, Ban{Kind: k.kind, Value: k.value, Until: until})
+		}
+		sort.Slice(bans, func(i, j int) bool { return bans[i].Until.Before(bans[j].Until) })
+		return bans
+	}).([]Ban)
+}
+
+// Clear removes the ban (and the failures) of
+// the specified kind (BanIP or BanUser) and value.
+// It returns false if there was no such ban.
+func (t *Tracker) Clear(kind, value string) bool {
+	return t.handler.Serialize(func() interface{} {
+		k := key{kind: kind, value: value}
+		_, ok := t.bans[k]
+		delete(t.bans, k)
+		delete(t.failures, k)
+		return ok
+	}).(bool)
+}
+
+// ClearAll removes every ban and failure
+func (t *Tracker) ClearAll() {
+	t.handler.Serialize(func() interface{} {
+		t.bans = make(map[key]time.Time)
+		t.failures = make(map[key][]time.Time)
+		return nil
+	})
+}
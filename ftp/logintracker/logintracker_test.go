@@ -0,0 +1,92 @@
+package logintracker
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDelay(t *testing.T) {
+	tr := New(DefaultPolicy())
+
+	assert.Equal(t, time.Duration(0), tr.Delay(0))
+	assert.Equal(t, time.Second, tr.Delay(1))
+	assert.Equal(t, 2*time.Second, tr.Delay(2))
+	assert.Equal(t, 4*time.Second, tr.Delay(3))
+	assert.Equal(t, 8*time.Second, tr.Delay(10))
+}
+
+func TestTooManyAttempts(t *testing.T) {
+	tr := New(DefaultPolicy())
+
+	assert.False(t, tr.TooManyAttempts(4))
+	assert.True(t, tr.TooManyAttempts(5))
+}
+
+func TestBan(t *testing.T) {
+	p := DefaultPolicy()
+	p.MaxFailures = 3
+	tr := New(p)
+
+	tr.Failure("10.0.0.1", "admin")
+	tr.Failure("10.0.0.1", "admin")
+	assert.False(t, tr.IsIPBanned("10.0.0.1"))
+
+	tr.Failure("10.0.0.2", "admin")
+	assert.False(t, tr.IsIPBanned("10.0.0.1"))
+	assert.True(t, tr.IsUserBanned("admin"))
+
+	tr.Failure("10.0.0.1", "root")
+	assert.True(t, tr.IsIPBanned("10.0.0.1"))
+	assert.False(t, tr.IsUserBanned("root"))
+
+	assert.Len(t, tr.Bans(), 2)
+
+	assert.True(t, tr.Clear(BanIP, "10.0.0.1"))
+	assert.False(t, tr.IsIPBanned("10.0.0.1"))
+	assert.False(t, tr.Clear(BanIP, "10.0.0.1"))
+
+	tr.ClearAll()
+	assert.Len(t, tr.Bans(), 0)
+}
+
+func TestBanExpires(t *testing.T) {
+	p := DefaultPolicy()
+	p.MaxFailures = 1
+	p.BanDuration = time.Millisecond
+	tr := New(p)
+
+	tr.Failure("10.0.0.1", "")
+	time.Sleep(5 * time.Millisecond)
+
+	assert.False(t, tr.IsIPBanned("10.0.0.1"))
+}
+
+func TestStaleEntriesAreDropped(t *testing.T) {
+	p := DefaultPolicy()
+	p.MaxFailures = 2
+	p.Window = 20 * time.Millisecond
+	p.BanDuration = 20 * time.Millisecond
+	tr := New(p)
+
+	// each round uses new usernames and addresses:
+	// half of them fail once, half get banned
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 50; i++ {
+			tr.Failure(fmt.Sprintf("10.%d.0.%d", round, i), fmt.Sprintf("once%d-%d", round, i))
+			banned := fmt.Sprintf("10.%d.1.%d", round, i)
+			tr.Failure(banned, fmt.Sprintf("twice%d-%d", round, i))
+			tr.Failure(banned, fmt.Sprintf("twice%d-%d", round, i))
+		}
+		time.Sleep(30 * time.Millisecond)
+	}
+
+	// only the last round is left
+	tr.handler.Serialize(func() interface{} {
+		assert.True(t, len(tr.failures) <= 100, "%d failures", len(tr.failures))
+		assert.True(t, len(tr.bans) <= 100, "%d bans", len(tr.bans))
+		return nil
+	})
+}
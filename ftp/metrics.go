@@ -0,0 +1,47 @@
+package ftp
+
+import (
+	"strconv"
+
+	"github.com/mindflavor/ftpserver2/ftp/metrics"
+)
+
+// SetMetrics enables the metrics collection for the
+// sessions created from now on and registers the server
+// gauges (active sessions and passive ports) in m.
+// Serve m.Handler() to expose them.
+func (srv *Server) SetMetrics(m *metrics.Metrics) {
+	srv.metrics = m
+
+	m.AddGauge("ftp_sessions_active", "Connected sessions by control connection encryption and login state", srv.sessionSamples)
+	m.AddGauge("ftp_passive_ports_in_use", "Passive ports assigned to the data connections", func() []metrics.Sample {
+		return []metrics.Sample{{Value: float64(srv.pa.InUse())}}
+	})
+}
+
+// sessionSamples counts the active sessions
+// by encryption and login state
+func (srv *Server) sessionSamples() []metrics.Sample {
+	counts := srv.handler.Serialize(func() interface{} {
+		counts := make(map[[2]bool]int)
+		for _, s := range srv.activeSessions {
+			if s == nil {
+				continue
+			}
+			secure, authenticated := s.State()
+			counts[[2]bool{secure, authenticated}]++
+		}
+		return counts
+	}).(map[[2]bool]int)
+
+	var samples []metrics.Sample
+	for _, secure := range []bool{false, true} {
+		for _, authenticated := range []bool{false, true} {
+			samples = append(samples, metrics.Sample{
+				Labels: metrics.Labels{"tls": strconv.FormatBool(secure), "authenticated": strconv.FormatBool(authenticated)},
+				Value:  float64(counts[[2]bool{secure, authenticated}]),
+			})
+		}
+	}
+	return samples
+}
@@ -0,0 +1,145 @@
+package metrics
+
+import (
+	"os"
+	"time"
+
+	"github.com/mindflavor/ftpserver2/ftp/fs"
+	"github.com/mindflavor/ftpserver2/identity"
+)
+
+// WrapFileProvider returns a fs.FileProvider timing
+// every method of fp. It's a fs.Wrapper: the optional
+// capabilities of fp are forwarded.
+func WrapFileProvider(fp fs.FileProvider, m *Metrics) fs.FileProvider {
+	if m == nil {
+		return fp
+	}
+
+	return &fileProvider{fp: fp, m: m}
+}
+
+type fileProvider struct {
+	fp fs.FileProvider
+	m  *Metrics
+}
+
+func (w *fileProvider) observe(method string, start time.Time) {
+	w.m.FSOperation(method, time.Since(start))
+}
+
+func (w *fileProvider) Identity() identity.Identity {
+	return w.fp.Identity()
+}
+
+func (w *fileProvider) SetIdentity(identity identity.Identity) {
+	w.fp.SetIdentity(identity)
+}
+
+func (w *fileProvider) Clone() fs.FileProvider {
+	return WrapFileProvider(w.fp.Clone(), w.m)
+}
+
+func (w *fileProvider) New(name string, isDirectory bool) (fs.File, error) {
+	defer w.observe("New", time.Now())
+	return w.fp.New(name, isDirectory)
+}
+
+func (w *fileProvider) Get(filename string) (fs.File, error) {
+	defer w.observe("Get", time.Now())
+	return w.fp.Get(filename)
+}
+
+func (w *fileProvider) List() ([]fs.File, error) {
+	defer w.observe("List", time.Now())
+	return w.fp.List()
+}
+
+func (w *fileProvider) CurrentDirectory() string {
+	return w.fp.CurrentDirectory()
+}
+
+func (w *fileProvider) ChangeDirectory(path string) error {
+	defer w.observe("ChangeDirectory", time.Now())
+	return w.fp.ChangeDirectory(path)
+}
+
+func (w *fileProvider) CreateDirectory(name string) error {
+	defer w.observe("CreateDirectory", time.Now())
+	return w.fp.CreateDirectory(name)
+}
+
+func (w *fileProvider) RemoveDirectory(name string) error {
+	defer w.observe("RemoveDirectory", time.Now())
+	return w.fp.RemoveDirectory(name)
+}
+
+// Unwrap implements fs.Wrapper
+func (w *fileProvider) Unwrap() fs.FileProvider {
+	return w.fp
+}
+
+func (w *fileProvider) ListEach(fn func(f fs.File) error) error {
+	ls, ok := w.fp.(fs.ListStreamer)
+	if !ok {
+		return fs.ErrNotSupported
+	}
+
+	// fn writes the entries to the client: the time
+	// spent there is not the backend latency
+	start := time.Now()
+	var inFn time.Duration
+	err := ls.ListEach(func(f fs.File) error {
+		t := time.Now()
+		defer func() { inFn += time.Since(t) }()
+		return fn(f)
+	})
+
+	w.m.FSOperation("ListEach", time.Since(start)-inFn)
+	return err
+}
+
+func (w *fileProvider) RemoveDirectoryRecursive(name string) error {
+	rr, ok := w.fp.(fs.RecursiveRemover)
+	if !ok {
+		return fs.ErrNotSupported
+	}
+	defer w.observe("RemoveDirectoryRecursive", time.Now())
+	return rr.RemoveDirectoryRecursive(name)
+}
+
+func (w *fileProvider) Rename(from, to string) error {
+	rn, ok := w.fp.(fs.Renamer)
+	if !ok {
+		return fs.ErrNotSupported
+	}
+	defer w.observe("Rename", time.Now())
+	return rn.Rename(from, to)
+}
+
+func (w *fileProvider) Chmod(name string, mode os.FileMode) error {
+	mc, ok := w.fp.(fs.ModeChanger)
+	if !ok {
+		return fs.ErrNotSupported
+	}
+	defer w.observe("Chmod", time.Now())
+	return mc.Chmod(name, mode)
+}
+
+func (w *fileProvider) Chtimes(name string, mtime time.Time) error {
+	tc, ok := w.fp.(fs.TimesChanger)
+	if !ok {
+		return fs.ErrNotSupported
+	}
+	defer w.observe("Chtimes", time.Now())
+	return tc.Chtimes(name, mtime)
+}
+
+func (w *fileProvider) Chown(name string, owner, group string) error {
+	oc, ok := w.fp.(fs.OwnerChanger)
+	if !ok {
+		return fs.ErrNotSupported
+	}
+	defer w.observe("Chown", time.Now())
+	return oc.Chown(name, owner, group)
+}
@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/mindflavor/ftpserver2/ftp/fs"
+	"github.com/stretchr/testify/assert"
+)
+
+// streamer is a fs.ListStreamer
+// returning two entries
+type streamer struct {
+	fs.FileProvider
+}
+
+func (streamer) ListEach(fn func(f fs.File) error) error {
+	for i := 0; i < 2; i++ {
+		if err := fn(nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestListEachTimesTheBackendOnly(t *testing.T) {
+	m := New()
+	fp := WrapFileProvider(streamer{}, m)
+
+	// a slow client
+	err := fp.(fs.ListStreamer).ListEach(func(f fs.File) error {
+		time.Sleep(100 * time.Millisecond)
+		return nil
+	})
+	assert.NoError(t, err)
+
+	buf := new(bytes.Buffer)
+	m.WriteTo(buf)
+	assert.Contains(t, buf.String(), `ftp_fs_operation_duration_seconds_bucket{le="0.1",method="ListEach"} 1`)
+}
@@ -0,0 +1,282 @@
+// Package metrics collects the FTP server metrics
+// and exposes them in the Prometheus text format.
+// Every method of a nil *Metrics is a no-op so the
+// instrumented code does not need to check if the
+// metrics are enabled.
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Transfer directions
+const (
+	Download = "download"
+	Upload   = "upload"
+)
+
+// DefaultDurationBuckets are the upper bounds (in seconds)
+// of the transfer duration histogram buckets
+var DefaultDurationBuckets = []float64{0.1, 0.5, 1, 5, 10, 30, 60, 300, 900, 3600}
+
+// DefaultLatencyBuckets are the upper bounds (in seconds)
+// of the file system latency histogram buckets
+var DefaultLatencyBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 5}
+
+// Labels are the labels of a sample
+type Labels map[string]string
+
+// Sample is a value returned by a GaugeFunc
+type Sample struct {
+	Labels Labels
+	Value  float64
+}
+
+// GaugeFunc is evaluated at every scrape
+type GaugeFunc func() []Sample
+
+type gauge struct {
+	name string
+	help string
+	f    GaugeFunc
+}
+
+type histogram struct {
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+	}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, b := range h.buckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// Metrics holds the server metrics
+type Metrics struct {
+	mu        sync.Mutex
+	commands  map[[2]string]uint64
+	logins    map[string]uint64
+	bytes     map[string]uint64
+	durations map[string]*histogram
+	fsLatency map[string]*histogram
+	gauges    []gauge
+}
+
+// New creates an empty Metrics
+func New() *Metrics {
+	return &Metrics{
+		commands:  make(map[[2]string]uint64),
+		logins:    make(map[string]uint64),
+		bytes:     make(map[string]uint64),
+		durations: make(map[string]*histogram),
+		fsLatency: make(map[string]*histogram),
+	}
+}
+
+// AddGauge adds a gauge evaluated at every scrape
+func (m *Metrics) AddGauge(name, help string, f GaugeFunc) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.gauges = append(m.gauges, gauge{name: name, help: help, f: f})
+}
+
+// Command counts a command and its reply code
+func (m *Metrics) Command(verb, code string) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.commands[[2]string{verb, code}]++
+}
+
+// Login counts a login attempt
+func (m *Metrics) Login(success bool) {
+	if m == nil {
+		return
+	}
+
+	result := "failure"
+	if success {
+		result = "success"
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.logins[result]++
+}
+
+// Transfer records a completed data transfer
+func (m *Metrics) Transfer(direction string, bytes int64, d time.Duration) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.bytes[direction] += uint64(bytes)
+
+	h := m.durations[direction]
+	if h == nil {
+		h = newHistogram(DefaultDurationBuckets)
+		m.durations[direction] = h
+	}
+	h.observe(d.Seconds())
+}
+
+// FSOperation records the latency of a
+// fs.FileProvider method
+func (m *Metrics) FSOperation(method string, d time.Duration) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h := m.fsLatency[method]
+	if h == nil {
+		h = newHistogram(DefaultLatencyBuckets)
+		m.fsLatency[method] = h
+	}
+	h.observe(d.Seconds())
+}
+
+// WriteTo writes the metrics in the Prometheus text format
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	buf := new(bytes.Buffer)
+
+	m.mu.Lock()
+	gauges := make([]gauge, len(m.gauges))
+	copy(gauges, m.gauges)
+
+	writeHeader(buf, "ftp_commands_total", "Commands received by verb and reply code", "counter")
+	keys := make([][2]string, 0, len(m.commands))
+	for k := range m.commands {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	for _, k := range keys {
+		writeSample(buf, "ftp_commands_total", Labels{"command": k[0], "code": k[1]}, float64(m.commands[k]))
+	}
+
+	writeHeader(buf, "ftp_logins_total", "Login attempts by result", "counter")
+	for _, result := range sortedKeys(m.logins) {
+		writeSample(buf, "ftp_logins_total", Labels{"result": result}, float64(m.logins[result]))
+	}
+
+	writeHeader(buf, "ftp_transfer_bytes_total", "Bytes transferred on the data connections by direction", "counter")
+	for _, direction := range sortedKeys(m.bytes) {
+		writeSample(buf, "ftp_transfer_bytes_total", Labels{"direction": direction}, float64(m.bytes[direction]))
+	}
+
+	writeHistograms(buf, "ftp_transfer_duration_seconds", "Data transfer durations by direction", "direction", m.durations)
+	writeHistograms(buf, "ftp_fs_operation_duration_seconds", "File system backend latencies by method", "method", m.fsLatency)
+	m.mu.Unlock()
+
+	// gauges are evaluated without holding the lock
+	for _, g := range gauges {
+		writeHeader(buf, g.name, g.help, "gauge")
+		for _, s := range g.f() {
+			writeSample(buf, g.name, s.Labels, s.Value)
+		}
+	}
+
+	return buf.WriteTo(w)
+}
+
+// Handler returns the HTTP handler
+// serving the metrics
+func (m *Metrics) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		m.WriteTo(w)
+	})
+}
+
+func writeHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeSample(w io.Writer, name string, labels Labels, value float64) {
+	fmt.Fprintf(w, "%s%s %v\n", name, formatLabels(labels), value)
+}
+
+func writeHistograms(w io.Writer, name, help, label string, hs map[string]*histogram) {
+	writeHeader(w, name, help, "histogram")
+
+	keys := make([]string, 0, len(hs))
+	for k := range hs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		h := hs[key]
+		for i, b := range h.buckets {
+			writeSample(w, name+"_bucket", Labels{label: key, "le": fmt.Sprintf("%v", b)}, float64(h.counts[i]))
+		}
+		writeSample(w, name+"_bucket", Labels{label: key, "le": "+Inf"}, float64(h.count))
+		writeSample(w, name+"_sum", Labels{label: key}, h.sum)
+		writeSample(w, name+"_count", Labels{label: key}, float64(h.count))
+	}
+}
+
+func formatLabels(labels Labels) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(labels))
+	for n := range labels {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, len(names))
+	for i, n := range names {
+		pairs[i] = fmt.Sprintf("%s=%q", n, labels[n])
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func sortedKeys(m map[string]uint64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
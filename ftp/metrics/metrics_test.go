@@ -0,0 +1,48 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteTo(t *testing.T) {
+	m := New()
+	m.Command("USER", "331")
+	m.Command("USER", "331")
+	m.Command("PASS", "530")
+	m.Login(false)
+	m.Login(true)
+	m.Transfer(Download, 1000, 2*time.Second)
+	m.FSOperation("List", 3*time.Millisecond)
+	m.AddGauge("ftp_passive_ports_in_use", "Passive ports in use", func() []Sample {
+		return []Sample{{Value: 3}}
+	})
+
+	buf := new(bytes.Buffer)
+	_, err := m.WriteTo(buf)
+	assert.NoError(t, err)
+	out := buf.String()
+
+	assert.Contains(t, out, `ftp_commands_total{code="331",command="USER"} 2`)
+	assert.Contains(t, out, `ftp_commands_total{code="530",command="PASS"} 1`)
+	assert.Contains(t, out, `ftp_logins_total{result="failure"} 1`)
+	assert.Contains(t, out, `ftp_transfer_bytes_total{direction="download"} 1000`)
+	assert.Contains(t, out, `ftp_transfer_duration_seconds_bucket{direction="download",le="1"} 0`)
+	assert.Contains(t, out, `ftp_transfer_duration_seconds_bucket{direction="download",le="5"} 1`)
+	assert.Contains(t, out, `ftp_transfer_duration_seconds_count{direction="download"} 1`)
+	assert.Contains(t, out, `ftp_fs_operation_duration_seconds_bucket{le="0.005",method="List"} 1`)
+	assert.Contains(t, out, "ftp_passive_ports_in_use 3\n")
+	assert.True(t, strings.Contains(out, "# TYPE ftp_passive_ports_in_use gauge"))
+}
+
+func TestNilMetrics(t *testing.T) {
+	var m *Metrics
+	m.Command("USER", "331")
+	m.Login(true)
+	m.Transfer(Upload, 1, time.Second)
+	m.FSOperation("Get", time.Second)
+}
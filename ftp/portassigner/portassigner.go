@@ -18,6 +18,7 @@ const noMorePorts = -1
 type PortAssigner interface {
 	AssignPort() (int, error)
 	ReleasePort(port int)
+	InUse() int
 	Close()
 }
 
@@ -82,6 +83,13 @@ func (pa *paService) ReleasePort(port int) {
 	})
 }
 
+// InUse returns the number of assigned ports
+func (pa *paService) InUse() int {
+	return pa.handler.Serialize(func() interface{} {
+		return len(pa.cAssigned) - pa.free
+	}).(int)
+}
+
 func (pa *paService) Close() {
 	pa.handler.Close()
 }
@@ -58,7 +58,10 @@ func TestAssignAndRelease(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, 10001, port)
 
+	assert.Equal(t, 2, pa.InUse())
+
 	pa.ReleasePort(10000)
+	assert.Equal(t, 1, pa.InUse())
 
 	port, err = pa.AssignPort()
 	assert.NoError(t, err)
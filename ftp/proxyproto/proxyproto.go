@@ -0,0 +1,261 @@
+// Package proxyproto implements the receiving side
+// of the HAProxy PROXY protocol (versions 1 and 2).
+// A load balancer sends a PROXY header before the
+// actual stream so the server can know the real client
+// address. Headers are accepted only from trusted proxies.
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// v2Signature is the fixed preamble of a version 2 header
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// v1Prefix starts a version 1 header
+var v1Prefix = []byte("PROXY ")
+
+// v1MaxLength is the maximum length of a version 1 header (CRLF included)
+const v1MaxLength = 107
+
+// DefaultTimeout is the time a trusted proxy has
+// to send the header after the connection is accepted
+const DefaultTimeout = 10 * time.Second
+
+// Listener wraps a net.Listener. Connections coming
+// from a trusted proxy must start with a PROXY header,
+// connections from other addresses are returned unchanged.
+type Listener struct {
+	net.Listener
+	trusted []*net.IPNet
+	timeout time.Duration
+}
+
+// NewListener creates a Listener accepting the
+// PROXY headers from the trusted networks.
+// timeout is the maximum time to wait for the header
+// (0 means DefaultTimeout).
+func NewListener(l net.Listener, trusted []*net.IPNet, timeout time.Duration) *Listener {
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+
+	return &Listener{
+		Listener: l,
+		trusted:  trusted,
+		timeout:  timeout,
+	}
+}
+
+// Accept waits for the next connection. The PROXY header is
+// not read here (that would block the accept loop) but on the
+// first Read or RemoteAddr call of the returned connection.
+func (l *Listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	if !l.isTrusted(c.RemoteAddr()) {
+		return c, nil
+	}
+
+	return &Conn{
+		Conn:    c,
+		bufr:    bufio.NewReader(c),
+		timeout: l.timeout,
+	}, nil
+}
+
+func (l *Listener) isTrusted(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+
+	for _, n := range l.trusted {
+		if n.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// Conn is a connection from a trusted proxy.
+// RemoteAddr returns the client address
+// carried by the PROXY header.
+type Conn struct {
+	net.Conn
+	bufr    *bufio.Reader
+	timeout time.Duration
+
+	once   sync.Once
+	remote net.Addr
+	err    error
+}
+
+// Read reads the data following the PROXY header
+func (c *Conn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.bufr.Read(b)
+}
+
+// RemoteAddr returns the client address. If the header
+// is invalid or it's a LOCAL (health check) one the
+// proxy address is returned.
+func (c *Conn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.remote == nil {
+		return c.Conn.RemoteAddr()
+	}
+	return c.remote
+}
+
+// Err reads the PROXY header (if not done yet)
+// and returns the parsing error, if any
+func (c *Conn) Err() error {
+	c.once.Do(c.readHeader)
+	return c.err
+}
+
+// ProxyAddr returns the address of the proxy
+func (c *Conn) ProxyAddr() net.Addr {
+	return c.Conn.RemoteAddr()
+}
+
+func (c *Conn) readHeader() {
+	c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+	defer c.Conn.SetReadDeadline(time.Time{})
+
+	c.remote, c.err = ReadHeader(c.bufr)
+	if c.err != nil {
+		c.err = fmt.Errorf("invalid PROXY header from %s: %s", c.Conn.RemoteAddr(), c.err)
+	}
+}
+
+// ReadHeader parses either a version 1 or version 2
+// PROXY header. It returns a nil address (and no error)
+// for the headers that do not carry the client address
+// (UNKNOWN or LOCAL).
+func ReadHeader(r *bufio.Reader) (net.Addr, error) {
+	// peek no more than the shortest header
+	// or a connection that sent it would block
+	b, err := r.Peek(len(v1Prefix))
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.Equal(b, v1Prefix) {
+		return readV1(r)
+	}
+	if !bytes.HasPrefix(v2Signature, b) {
+		return nil, fmt.Errorf("missing PROXY header")
+	}
+
+	b, err = r.Peek(len(v2Signature))
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(b, v2Signature) {
+		return nil, fmt.Errorf("missing PROXY header")
+	}
+
+	return readV2(r)
+}
+
+func readV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < v1MaxLength {
+		c, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, c)
+		if c == '\n' {
+			break
+		}
+	}
+
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, fmt.Errorf("version 1 header too long or not terminated by CRLF")
+	}
+
+	toks := strings.Split(string(line[:len(line)-2]), " ")
+	if len(toks) >= 2 && toks[1] == "UNKNOWN" {
+		return nil, nil
+	}
+
+	if len(toks) != 6 || (toks[1] != "TCP4" && toks[1] != "TCP6") {
+		return nil, fmt.Errorf("malformed version 1 header %q", line)
+	}
+
+	ip := net.ParseIP(toks[2])
+	if ip == nil {
+		return nil, fmt.Errorf("invalid source address %s", toks[2])
+	}
+
+	port, err := strconv.ParseUint(toks[4], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source port %s", toks[4])
+	}
+
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+func readV2(r *bufio.Reader) (net.Addr, error) {
+	hdr := make([]byte, 16)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, err
+	}
+
+	if hdr[12]>>4 != 2 {
+		return nil, fmt.Errorf("unsupported version %d", hdr[12]>>4)
+	}
+
+	payload := make([]byte, binary.BigEndian.Uint16(hdr[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	switch hdr[12] & 0x0f {
+	case 0x0: // LOCAL
+		return nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, fmt.Errorf("unsupported command %d", hdr[12]&0x0f)
+	}
+
+	switch hdr[13] {
+	case 0x11: // TCP over IPv4
+		if len(payload) < 12 {
+			return nil, fmt.Errorf("short IPv4 address block")
+		}
+		return &net.TCPAddr{
+			IP:   net.IP(payload[0:4]),
+			Port: int(binary.BigEndian.Uint16(payload[8:10])),
+		}, nil
+	case 0x21: // TCP over IPv6
+		if len(payload) < 36 {
+			return nil, fmt.Errorf("short IPv6 address block")
+		}
+		return &net.TCPAddr{
+			IP:   net.IP(payload[0:16]),
+			Port: int(binary.BigEndian.Uint16(payload[32:34])),
+		}, nil
+	}
+
+	// UNSPEC or a non TCP protocol
+	return nil, nil
+}
@@ -0,0 +1,120 @@
+package proxyproto
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadHeaderV1(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("PROXY TCP4 192.168.0.1 10.0.0.1 56324 21\r\nUSER test\r\n"))
+	addr, err := ReadHeader(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "192.168.0.1:56324", addr.String())
+
+	rest, _ := ioutil.ReadAll(r)
+	assert.Equal(t, "USER test\r\n", string(rest))
+
+	addr, err = ReadHeader(bufio.NewReader(strings.NewReader("PROXY TCP6 2001:db8::1 2001:db8::2 4000 21\r\n")))
+	assert.NoError(t, err)
+	assert.Equal(t, "[2001:db8::1]:4000", addr.String())
+
+	addr, err = ReadHeader(bufio.NewReader(strings.NewReader("PROXY UNKNOWN\r\n")))
+	assert.NoError(t, err)
+	assert.Nil(t, addr)
+
+	_, err = ReadHeader(bufio.NewReader(strings.NewReader("PROXY TCP4 nonsense\r\n")))
+	assert.Error(t, err)
+
+	_, err = ReadHeader(bufio.NewReader(strings.NewReader("USER anonymous\r\n")))
+	assert.Error(t, err)
+}
+
+func TestReadHeaderShort(t *testing.T) {
+	// the connection stays open after the header:
+	// ReadHeader must not wait for more bytes
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	go client.Write([]byte("PROXY UNKNOWN\r\n"))
+
+	server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	addr, err := ReadHeader(bufio.NewReader(server))
+	assert.NoError(t, err)
+	assert.Nil(t, addr)
+
+	go client.Write([]byte("QUIT\r\n"))
+
+	_, err = ReadHeader(bufio.NewReader(server))
+	assert.EqualError(t, err, "missing PROXY header")
+}
+
+func TestReadHeaderV2(t *testing.T) {
+	hdr := append([]byte{}, v2Signature...)
+	hdr = append(hdr, 0x21, 0x11, 0x00, 0x0c)
+	hdr = append(hdr, 192, 168, 0, 1, 10, 0, 0, 1, 0xdc, 0x04, 0x00, 0x15)
+	hdr = append(hdr, []byte("USER test\r\n")...)
+
+	r := bufio.NewReader(strings.NewReader(string(hdr)))
+	addr, err := ReadHeader(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "192.168.0.1:56324", addr.String())
+
+	rest, _ := ioutil.ReadAll(r)
+	assert.Equal(t, "USER test\r\n", string(rest))
+
+	local := append(append([]byte{}, v2Signature...), 0x20, 0x00, 0x00, 0x00)
+	addr, err = ReadHeader(bufio.NewReader(strings.NewReader(string(local))))
+	assert.NoError(t, err)
+	assert.Nil(t, addr)
+}
+
+func TestListener(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer l.Close()
+
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	pl := NewListener(l, []*net.IPNet{loopback}, 0)
+
+	go func() {
+		c, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		c.Write([]byte("PROXY TCP4 203.0.113.7 10.0.0.1 4000 21\r\nNOOP\r\n"))
+	}()
+
+	c, err := pl.Accept()
+	assert.NoError(t, err)
+	defer c.Close()
+
+	assert.Equal(t, "203.0.113.7:4000", c.RemoteAddr().String())
+
+	line, err := bufio.NewReader(c).ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "NOOP\r\n", line)
+
+	// untrusted connections are left alone
+	pl = NewListener(l, nil, 0)
+	go func() {
+		c, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			return
+		}
+		c.Close()
+	}()
+
+	c2, err := pl.Accept()
+	assert.NoError(t, err)
+	defer c2.Close()
+	_, isProxied := c2.(*Conn)
+	assert.False(t, isProxied)
+}
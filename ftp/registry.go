@@ -0,0 +1,21 @@
+package ftp
+
+import "github.com/mindflavor/ftpserver2/ftp/session"
+
+// RegisterCommand adds a custom command or
+// replaces a built in one (same verb). It's
+// effective on the active sessions too.
+func (srv *Server) RegisterCommand(c session.Command) {
+	srv.registry.Register(c)
+}
+
+// UnregisterCommand disables the command verb
+func (srv *Server) UnregisterCommand(verb string) {
+	srv.registry.Unregister(verb)
+}
+
+// RegisterSITE adds a SITE subcommand (SITE <verb> ...)
+// or replaces the one with the same verb
+func (srv *Server) RegisterSITE(c session.Command) {
+	srv.registry.RegisterSITE(c)
+}
@@ -0,0 +1,46 @@
+package session
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/mindflavor/ftpserver2/ftp/auditlog"
+	"github.com/mindflavor/ftpserver2/ftp/datachannel"
+)
+
+// SetAuditLog sets the transfer audit log
+// of the session. It can be nil.
+func (ses *Session) SetAuditLog(l *auditlog.Logger) {
+	ses.auditLog = l
+}
+
+// transferRecord prepares the audit record of a transfer
+// on dc. The SinkFunction sets its start Time.
+func (ses *Session) transferRecord(direction, path string, dc datachannel.DataChanneler) *auditlog.Record {
+	transferType := auditlog.Binary
+	if ses.transferType == typeASCII {
+		transferType = auditlog.ASCII
+	}
+
+	return &auditlog.Record{
+		RemoteHost:   ses.remoteIP(),
+		Path:         path,
+		Direction:    direction,
+		TransferType: transferType,
+		User:         ses.id.Username(),
+		TLS:          dc.Encrypted(),
+	}
+}
+
+// audit completes the record with the transfer
+// duration and writes it in the audit log
+func (ses *Session) audit(r *auditlog.Record) {
+	if ses.auditLog == nil {
+		return
+	}
+
+	r.Duration = time.Since(r.Time)
+	if err := ses.auditLog.Log(*r); err != nil {
+		log.WithFields(log.Fields{"ses": ses, "record": r, "err": err}).Warn("session::Session::audit audit log write failed")
+	}
+}
@@ -0,0 +1,69 @@
+package session
+
+import (
+	"crypto/x509"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/mindflavor/ftpserver2/identity"
+)
+
+// ClientCertMode tells how the verified client
+// certificates (mutual TLS) are used to log in
+type ClientCertMode int
+
+const (
+	// ClientCertIgnored logs in with the password only
+	ClientCertIgnored ClientCertMode = iota
+	// ClientCertSufficient logs in without password (USER replies 232)
+	// if the certificate maps to the user. The other users
+	// log in with the password.
+	ClientCertSufficient
+	// ClientCertRequired requires both the password and
+	// a certificate mapping to the user
+	ClientCertRequired
+)
+
+// CertificateAuthenticatorFunc maps a verified client
+// certificate to the identity of username. It must return
+// nil if the certificate does not belong to the user.
+type CertificateAuthenticatorFunc func(username string, cert *x509.Certificate) identity.Identity
+
+// SetClientCertAuth enables the client certificate authentication.
+// The tls.Config must request and verify the client certificates
+// (ClientAuth and ClientCAs) otherwise no certificate is
+// ever available.
+func (ses *Session) SetClientCertAuth(mode ClientCertMode, f CertificateAuthenticatorFunc) {
+	ses.clientCertMode = mode
+	ses.certAuthFunc = f
+}
+
+// clientCertificate returns the verified client certificate
+// of the control connection (nil if there is none)
+func (ses *Session) clientCertificate() *x509.Certificate {
+	cs := ses.conn.ConnectionState()
+	if cs == nil || len(cs.VerifiedChains) == 0 || len(cs.VerifiedChains[0]) == 0 {
+		return nil
+	}
+
+	return cs.VerifiedChains[0][0]
+}
+
+// certificateIdentity returns the identity the client
+// certificate maps to for username (nil if there is no
+// certificate or it does not belong to the user)
+func (ses *Session) certificateIdentity(username string) identity.Identity {
+	if ses.certAuthFunc == nil {
+		return nil
+	}
+
+	cert := ses.clientCertificate()
+	if cert == nil {
+		return nil
+	}
+
+	id := ses.certAuthFunc(username, cert)
+
+	log.WithFields(log.Fields{"ses": ses, "username": username, "cert.Subject": cert.Subject.String(), "mapped": id != nil}).Debug("session::Session::certificateIdentity client certificate checked")
+
+	return id
+}
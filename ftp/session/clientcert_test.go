@@ -0,0 +1,62 @@
+package session
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"strings"
+	"testing"
+
+	"github.com/mindflavor/ftpserver2/identity"
+	"github.com/mindflavor/ftpserver2/identity/basic"
+	"github.com/stretchr/testify/assert"
+)
+
+func byCommonName(username string, cert *x509.Certificate) identity.Identity {
+	if cert.Subject.CommonName != username {
+		return nil
+	}
+	return basicidentity.New(username, false)
+}
+
+func withClientCertificate(conn *fakeConn, cn string) {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: cn}}
+	conn.state = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+}
+
+func TestClientCertSufficient(t *testing.T) {
+	conn := newFakeConn("10.0.0.1", true)
+	withClientCertificate(conn, "alice")
+	ses := newTestSession(conn)
+	ses.SetClientCertAuth(ClientCertSufficient, byCommonName)
+
+	ses.processUSER([]string{"USER", "alice"})
+	assert.True(t, strings.HasPrefix(conn.lastReply(), "232"))
+	assert.True(t, ses.id.Authenticated())
+
+	// someone else's certificate
+	conn = newFakeConn("10.0.0.1", true)
+	withClientCertificate(conn, "mallory")
+	ses = newTestSession(conn)
+	ses.SetClientCertAuth(ClientCertSufficient, byCommonName)
+
+	ses.processUSER([]string{"USER", "alice"})
+	assert.True(t, strings.HasPrefix(conn.lastReply(), "331"))
+	assert.False(t, ses.id.Authenticated())
+}
+
+func TestClientCertRequired(t *testing.T) {
+	conn := newFakeConn("10.0.0.1", true)
+	ses := newTestSession(conn)
+	ses.SetClientCertAuth(ClientCertRequired, byCommonName)
+
+	ses.processUSER([]string{"USER", "alice"})
+	assert.True(t, strings.HasPrefix(conn.lastReply(), "331"))
+	ses.processPASS([]string{"PASS", "secret"})
+	assert.True(t, strings.HasPrefix(conn.lastReply(), "530"))
+
+	withClientCertificate(conn, "alice")
+	ses.processUSER([]string{"USER", "alice"})
+	ses.processPASS([]string{"PASS", "secret"})
+	assert.True(t, strings.HasPrefix(conn.lastReply(), "230"))
+}
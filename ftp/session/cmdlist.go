@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	log "github.com/sirupsen/logrus"
+	"github.com/mindflavor/ftpserver2/identity"
 )
 
 type cmdlist struct {
@@ -36,6 +37,24 @@ func (cmd *cmdlist) requireAuth() *cmdlist {
 	return cmd
 }
 
+// requirePermission refuses the command if the
+// identity.Authorizer of the user denies permission
+func (cmd *cmdlist) requirePermission(permission string) *cmdlist {
+	if cmd.pe == nil || permission == "" {
+		return cmd
+	}
+
+	log.WithFields(log.Fields{"cmd": cmd, "permission": permission}).Debug("session::cmdList::requirePermission called")
+
+	if az, ok := cmd.ses.id.(identity.Authorizer); ok && !az.HasPermission(permission) {
+		cmd.ses.sendStatement("550 Permission denied.")
+		cmd.pe = nil
+		return cmd
+	}
+
+	return cmd
+}
+
 func (cmd *cmdlist) requirePASV() *cmdlist {
 	if cmd.pe == nil {
 		return cmd
@@ -52,6 +71,48 @@ func (cmd *cmdlist) requirePASV() *cmdlist {
 	return cmd
 }
 
+// requireTLS refuses the command if the
+// TLSPolicy requires an encrypted control connection
+func (cmd *cmdlist) requireTLS() *cmdlist {
+	if cmd.pe == nil {
+		return cmd
+	}
+
+	log.WithFields(log.Fields{"cmd": cmd}).Debug("session::cmdList::requireTLS called")
+
+	// USER carries the username, PASS relies on the previous USER
+	username := cmd.ses.id.Username()
+	if cmd.tokens[0] == commands[USER] && len(cmd.tokens) > 1 {
+		username = cmd.tokens[1]
+	}
+
+	if cmd.ses.controlTLSRequired(username) {
+		cmd.ses.sendStatement("530 TLS required, use AUTH TLS first.")
+		cmd.pe = nil
+		return cmd
+	}
+
+	return cmd
+}
+
+// requirePROT refuses the command if the
+// TLSPolicy requires an encrypted data connection
+func (cmd *cmdlist) requirePROT() *cmdlist {
+	if cmd.pe == nil {
+		return cmd
+	}
+
+	log.WithFields(log.Fields{"cmd": cmd}).Debug("session::cmdList::requirePROT called")
+
+	if !cmd.ses.dataChannelEncryption && cmd.ses.dataTLSRequired() {
+		cmd.ses.sendStatement("521 Data connections must be encrypted, use PROT P first.")
+		cmd.pe = nil
+		return cmd
+	}
+
+	return cmd
+}
+
 func (cmd *cmdlist) resetREST() *cmdlist {
 	cmd.ses.lastREST = 0
 
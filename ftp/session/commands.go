@@ -2,12 +2,22 @@ package session
 
 import (
 	"bytes"
+	"compress/zlib"
+	"encoding/hex"
 	"fmt"
+	"hash"
 	"io"
+	"strconv"
 	"strings"
 	"time"
 
 	log "github.com/sirupsen/logrus"
+	"github.com/mindflavor/ftpserver2/ftp/auditlog"
+	"github.com/mindflavor/ftpserver2/ftp/datachannel"
+	"github.com/mindflavor/ftpserver2/ftp/fs"
+	"github.com/mindflavor/ftpserver2/ftp/metrics"
+	"github.com/mindflavor/ftpserver2/ftp/throttle"
+	"github.com/mindflavor/ftpserver2/identity"
 )
 
 type processEntry func(tokens []string) bool
@@ -18,6 +28,13 @@ type processEntry func(tokens []string) bool
 // authenticated from there on
 type AuthenticatorFunc func(name, password string) bool
 
+// IdentityAuthenticatorFunc works like AuthenticatorFunc
+// but returns the identity.Identity of the user (or nil if
+// the credentials are invalid). Use it if you want to provide
+// per user settings by implementing the optional identity
+// interfaces (for example identity.BandwidthLimited).
+type IdentityAuthenticatorFunc func(name, password string) identity.Identity
+
 func (ses *Session) processSYST(tokens []string) bool {
 	log.WithFields(log.Fields{"ses": ses, "tokens": tokens, "command": "SYST"}).Info("session::Session::processSYST method begin")
 	ses.sendStatement("215 UNIX Type: L8")
@@ -41,12 +58,8 @@ func (ses *Session) processFEAT(tokens []string) bool {
 
 	buf.WriteString("211-Features:\r\n")
 
-	for _, cmd := range commands {
-		buf.WriteString(fmt.Sprintf(" %s\r\n", cmd))
-	}
-
-	if ses.cert != nil && !ses.conn.IsSecure() {
-		buf.WriteString(fmt.Sprintf(" %s\r\n", "AUTH"))
+	for _, line := range ses.registry.features(ses) {
+		buf.WriteString(fmt.Sprintf(" %s\r\n", line))
 	}
 
 	buf.WriteString("211 End")
@@ -56,6 +69,26 @@ func (ses *Session) processFEAT(tokens []string) bool {
 	return false
 }
 
+func (ses *Session) processOPTS(tokens []string) bool {
+	log.WithFields(log.Fields{"ses": ses, "tokens": tokens, "command": "OPTS"}).Info("session::Session::processOPTS method begin")
+
+	if len(tokens) < 2 {
+		ses.sendStatement("501 option needed!")
+		return false
+	}
+
+	switch strings.ToUpper(tokens[1]) {
+	case "HASH":
+		ses.optsHASH(tokens)
+	case "MODE":
+		ses.optsMODE(tokens)
+	default:
+		ses.sendStatement(fmt.Sprintf("501 option %s not understood", tokens[1]))
+	}
+
+	return false
+}
+
 func (ses *Session) processPWD(tokens []string) bool {
 	log.WithFields(log.Fields{"ses": ses, "tokens": tokens, "command": "PWD"}).Info("session::Session::processPWD method begin")
 	ses.sendStatement(fmt.Sprintf("257 \"%s\"", ses.fileProvider.CurrentDirectory()))
@@ -113,10 +146,22 @@ func (ses *Session) processRETR(tokens []string) bool {
 
 	dc := ses.lastDataChanneler
 	ses.lastDataChanneler = nil // dc in use!
+	ses.countTransfer(dc, metrics.Download)
+	ts := ses.transferSettings()
+
+	rec := ses.transferRecord(auditlog.Download, ses.absPath(file), dc)
+	algorithm := ses.hashAlgorithm
+	e := ses.newEvent(EventDownloadComplete, rec.Path)
 
 	dc.Sink(func(w io.Writer, r io.Reader) error {
 		defer dc.Close()
 
+		rec.Time = time.Now()
+		defer ses.audit(rec)
+
+		t := ses.startTransfer(auditlog.Download, rec.Path)
+		defer ses.endTransfer(t)
+
 		file, err := f.Read(rest)
 		if err != nil {
 			log.WithFields(log.Fields{"ses": ses, "tokens": tokens, "err": err}).Warn("session::Session::processRETR fs.File.Get failed")
@@ -125,9 +170,29 @@ func (ses *Session) processRETR(tokens []string) bool {
 		}
 		defer file.Close()
 
+		// the digest is only recorded in the audit log
+		var h hash.Hash
+		if ses.auditLog != nil {
+			h, err = fs.NewHash(algorithm)
+			if err != nil {
+				ses.sendStatement(fmt.Sprintf("550 Could not compute hash: %s.", err))
+				return err
+			}
+		}
+
+		w, flush, err := ts.dataWriter(throttle.NewWriter(w, ses.downloadLimiter, ses.globalDownloadLimiter))
+		if err != nil {
+			ses.sendStatement(fmt.Sprintf("451 Could not open data stream: %s.", err))
+			return err
+		}
+
+		if ts.ascii {
+			w = datachannel.NewASCIIWriter(w)
+		}
+
 		buf := make([]byte, 1024*256)
 
-		ses.sendStatement(fmt.Sprintf("150 Opening BINARY mode data connection for %s.", f.Name()))
+		ses.sendStatement(fmt.Sprintf("150 Opening %s mode data connection for %s.", ts.typeName, f.Name()))
 
 		log.WithFields(log.Fields{"ses": ses, "tokens": tokens, "f.FullPath()": f.FullPath(), "f.Size()": f.Size()}).Info("session::Session::processRETR transfer starting")
 
@@ -146,10 +211,31 @@ func (ses *Session) processRETR(tokens []string) bool {
 						return err
 					}
 					log.WithFields(log.Fields{"ses": ses, "tokens": tokens, "sent": iWritten, "f.Size()": f.Size()}).Debug("session::Session::processRETR transfer starting")
+					if h != nil {
+						h.Write(buf[0:iRead])
+					}
+					rec.Bytes += int64(iRead)
+					t.add(iRead)
+
+					if err := flush(); err != nil {
+						log.WithFields(log.Fields{"ses": ses, "tokens": tokens, "err": err}).Warn("session::Session::processRETR flush failed")
+						return err
+					}
+
+					if h != nil {
+						rec.Algorithm = algorithm
+						rec.Checksum = hex.EncodeToString(h.Sum(nil))
+					}
+					rec.Completed = true
+
+					// the data is already sent, a veto is meaningless
+					e.Size = rec.Bytes
+					ses.beforeEvent(e)
 
 					// done
-					log.WithFields(log.Fields{"ses": ses, "tokens": tokens}).Info("session::Session::processRETR transfer completed")
+					log.WithFields(log.Fields{"ses": ses, "tokens": tokens, "bytes": rec.Bytes}).Info("session::Session::processRETR transfer completed")
 					ses.sendStatement("226 File send OK.")
+					ses.afterEvent(e)
 					return nil
 				}
 
@@ -165,6 +251,11 @@ func (ses *Session) processRETR(tokens []string) bool {
 				return err
 			}
 			log.WithFields(log.Fields{"ses": ses, "tokens": tokens, "sent": iWritten, "f.Size()": f.Size()}).Debug("session::Session::processRETR transfer starting")
+			if h != nil {
+				h.Write(buf[0:iRead])
+			}
+			rec.Bytes += int64(iRead)
+			t.add(iRead)
 		}
 	})
 
@@ -179,7 +270,8 @@ func (ses *Session) processSTOR(tokens []string) bool {
 		return false
 	}
 
-	f, err := ses.fileProvider.New(strings.Join(tokens[1:], " "), false)
+	name := strings.Join(tokens[1:], " ")
+	f, err := ses.fileProvider.New(name, false)
 
 	if err != nil {
 		log.WithFields(log.Fields{"ses": ses, "tokens": tokens, "err": err}).Warn("session::Session::processSTOR fs.New failed")
@@ -189,31 +281,110 @@ func (ses *Session) processSTOR(tokens []string) bool {
 
 	dc := ses.lastDataChanneler
 	ses.lastDataChanneler = nil // dc in use!
+	ses.countTransfer(dc, metrics.Upload)
+	ts := ses.transferSettings()
+
+	rec := ses.transferRecord(auditlog.Upload, ses.absPath(name), dc)
+	algorithm := ses.hashAlgorithm
+	e := ses.newEvent(EventUploadComplete, rec.Path)
 
 	dc.Sink(func(w io.Writer, r io.Reader) error {
 		defer dc.Close()
 
+		rec.Time = time.Now()
+		defer ses.audit(rec)
+
+		t := ses.startTransfer(auditlog.Upload, rec.Path)
+		defer ses.endTransfer(t)
+
 		file, err := f.Write()
 		if err != nil {
 			log.WithFields(log.Fields{"ses": ses, "tokens": tokens, "err": err}).Warn("session::Session::processSTOR fs.File.Write failed")
 			ses.sendStatement(fmt.Sprintf("550 Could not get file: %s.", err))
 			return err
 		}
-		defer file.Close()
+		closed := false
+		defer func() {
+			if !closed {
+				file.Close()
+			}
+		}()
+
+		// digest computed while receiving, no need for a second read
+		h, err := fs.NewHash(algorithm)
+		if err != nil {
+			ses.sendStatement(fmt.Sprintf("550 Could not compute hash: %s.", err))
+			return err
+		}
+		dst := io.MultiWriter(file, h)
+		var received int64
+
+		r, err = ts.dataReader(throttle.NewReader(r, ses.uploadLimiter, ses.globalUploadLimiter))
+		if err != nil {
+			ses.sendStatement(fmt.Sprintf("451 Could not open data stream: %s.", err))
+			return err
+		}
+
+		if ts.ascii {
+			r = datachannel.NewASCIIReader(r)
+		}
 
 		buf := make([]byte, 1024*1024*100)
 
-		ses.sendStatement(fmt.Sprintf("150 Opening BINARY mode data connection for %s.", f.Name()))
+		ses.sendStatement(fmt.Sprintf("150 Opening %s mode data connection for %s.", ts.typeName, f.Name()))
 
 		log.WithFields(log.Fields{"ses": ses, "tokens": tokens, "f.FullPath()": f.FullPath(), "f.Size()": f.Size()}).Info("session::Session::processSTOR transfer starting")
 
 		for {
 			iRead, err := r.Read(buf)
+
+			// a reader can return the last bytes along with io.EOF
+			if iRead > 0 {
+				if _, err := dst.Write(buf[0:iRead]); err != nil {
+					// something went south :(
+					log.WithFields(log.Fields{"ses": ses, "tokens": tokens, "err": err}).Warn("session::Session::processSTOR file.Write failed")
+					return err
+				}
+				received += int64(iRead)
+				rec.Bytes = received
+				t.add(iRead)
+			}
+
 			if err != nil {
 				if err == io.EOF {
+					// commit the file before storing its digest
+					closed = true
+					if err := file.Close(); err != nil {
+						log.WithFields(log.Fields{"ses": ses, "tokens": tokens, "err": err}).Warn("session::Session::processSTOR file.Close failed")
+						ses.sendStatement(fmt.Sprintf("451 Could not save file: %s.", err))
+						return err
+					}
+
+					digest := hex.EncodeToString(h.Sum(nil))
+
+					if hs, ok := f.(fs.HashStorer); ok {
+						if err := hs.StoreHash(algorithm, h.Sum(nil)); err != nil {
+							log.WithFields(log.Fields{"ses": ses, "tokens": tokens, "algorithm": algorithm, "err": err}).Warn("session::Session::processSTOR StoreHash failed")
+						}
+					}
+
+					e.Size = received
+					if err := ses.beforeEvent(e); err != nil {
+						if err := f.Delete(); err != nil {
+							log.WithFields(log.Fields{"ses": ses, "tokens": tokens, "err": err}).Warn("session::Session::processSTOR vetoed file removal failed")
+						}
+						ses.sendStatement(fmt.Sprintf("550 Upload refused: %s.", err))
+						return err
+					}
+
+					rec.Algorithm = algorithm
+					rec.Checksum = digest
+					rec.Completed = true
+
 					// done
-					log.WithFields(log.Fields{"ses": ses, "tokens": tokens}).Info("session::Session::processSTOR transfer completed")
-					ses.sendStatement("226 File received OK.")
+					log.WithFields(log.Fields{"ses": ses, "tokens": tokens, "bytes": received, "algorithm": algorithm, "hash": digest}).Info("session::Session::processSTOR transfer completed")
+					ses.sendStatement(fmt.Sprintf("226 File received OK. %s %s", algorithm, digest))
+					ses.afterEvent(e)
 					return nil
 				}
 
@@ -221,13 +392,6 @@ func (ses *Session) processSTOR(tokens []string) bool {
 				log.WithFields(log.Fields{"ses": ses, "tokens": tokens, "err": err}).Warn("session::Session::processSTOR socket.Read failed")
 				return err
 			}
-
-			_, err = file.Write(buf[0:iRead])
-			if err != nil {
-				// something went south :(
-				log.WithFields(log.Fields{"ses": ses, "tokens": tokens, "err": err}).Warn("session::Session::processSTOR file.Write failed")
-				return err
-			}
 		}
 	})
 
@@ -237,77 +401,20 @@ func (ses *Session) processSTOR(tokens []string) bool {
 func (ses *Session) processLIST(tokens []string) bool {
 	log.WithFields(log.Fields{"ses": ses, "tokens": tokens, "command": "LIST"}).Info("session::Session::processLIST method begin")
 
-	lastCWD := ses.fileProvider.CurrentDirectory()
-
-	if len(tokens) > 1 {
-		if err := ses.fileProvider.ChangeDirectory(tokens[1]); err != nil {
-			ses.sendStatement(fmt.Sprintf("451 cannot retrieve directory list: %s", err))
-			return false
-		}
+	opts, arg := parseListArgs(tokens[1:])
+	opts.long = true
+	// without flags LIST shows the hidden files, . and .. as it always did
+	if !opts.flags {
+		opts.all = true
 	}
 
-	files, err := ses.fileProvider.List()
-
-	if err != nil {
+	l := ses.newListing(opts)
+	if err := l.prepare(arg); err != nil {
 		ses.sendStatement(fmt.Sprintf("451 cannot retrieve directory list: %s", err))
 		return false
 	}
 
-	if len(tokens) > 1 {
-		if err := ses.fileProvider.ChangeDirectory(lastCWD); err != nil {
-			ses.sendStatement(fmt.Sprintf("451 cannot retrieve directory list: %s", err))
-			return false
-		}
-	}
-
-	log.WithFields(log.Fields{"ses": ses, "tokens": tokens, "command": "LIST", "len(files)": len(files)}).Info("session::Session::processLIST method after ses.fileProvider.List()")
-
-	// prepare directory listing
-	buf := new(bytes.Buffer)
-	{
-		{ // send . and ..
-			str := fmt.Sprintf("%s   1 %-10s %-10s %10d Jan  02  2006 %s\r\n", "drwxrwxrwx", "group", "user", 0, ".")
-			buf.WriteString(str)
-			str = fmt.Sprintf("%s   1 %-10s %-10s %10d Jan  02  2006 %s\r\n", "drwxrwxrwx", "group", "user", 0, "..")
-			buf.WriteString(str)
-		}
-
-		for _, file := range files {
-			var date string
-			diff := time.Now().Sub(file.ModTime())
-			if diff.Hours() > 24*30*6 {
-				date = fmt.Sprintf("%3.3s %2d  %04d", file.ModTime().Month(), file.ModTime().Day(), file.ModTime().Year())
-			} else {
-				date = fmt.Sprintf("%3.3s %2d %02d:%02d", file.ModTime().Month(), file.ModTime().Day(), file.ModTime().Hour(), file.ModTime().Minute())
-			}
-
-			str := fmt.Sprintf("%s   1 %-10s %-10s %10d %s %s\r\n", file.Mode(), "group", "user", file.Size(), date, file.Name())
-			buf.WriteString(str)
-		}
-	}
-
-	dc := ses.lastDataChanneler
-	ses.lastDataChanneler = nil // dc in use!
-
-	log.WithFields(log.Fields{"ses": ses, "tokens": tokens, "command": "LIST", "len(files)": len(files)}).Info("session::Session::processLIST method after ses.lastDataChanneler = nil")
-
-	dc.Sink(func(w io.Writer, r io.Reader) error {
-		defer dc.Close()
-
-		log.WithFields(log.Fields{"w": w, "string(buf.Bytes())": string(buf.Bytes())}).Debug("session::Session::processLIST::anonymous sending directory list")
-
-		ses.sendStatement("150 Here comes the directory listing.")
-
-		_, err := w.Write(buf.Bytes())
-
-		if err != nil {
-			ses.sendStatement(fmt.Sprintf("550 Directory listing error: %s", err))
-			return err
-		}
-
-		ses.sendStatement("226 Directory send OK.")
-		return nil
-	})
+	ses.sendListing("LIST", l)
 
 	log.WithFields(log.Fields{"ses": ses, "tokens": tokens, "command": "LIST"}).Info("session::Session::processLIST method end with success")
 	return false
@@ -316,60 +423,19 @@ func (ses *Session) processLIST(tokens []string) bool {
 func (ses *Session) processNLST(tokens []string) bool {
 	log.WithFields(log.Fields{"ses": ses, "tokens": tokens, "command": "NLST"}).Info("session::Session::processNLST method begin")
 
-	lastCWD := ses.fileProvider.CurrentDirectory()
+	opts, arg := parseListArgs(tokens[1:])
 
-	if len(tokens) > 1 {
-		if err := ses.fileProvider.ChangeDirectory(tokens[1]); err != nil {
-			ses.sendStatement(fmt.Sprintf("451 cannot retrieve directory list: %s", err))
-			return false
-		}
+	l := ses.newListing(opts)
+	// without flags NLST shows the hidden files as it always did
+	if !opts.flags {
+		l.hidden = true
 	}
-
-	files, err := ses.fileProvider.List()
-
-	if err != nil {
+	if err := l.prepare(arg); err != nil {
 		ses.sendStatement(fmt.Sprintf("451 cannot retrieve directory list: %s", err))
 		return false
 	}
 
-	if len(tokens) > 1 {
-		if err := ses.fileProvider.ChangeDirectory(lastCWD); err != nil {
-			ses.sendStatement(fmt.Sprintf("451 cannot retrieve directory list: %s", err))
-			return false
-		}
-	}
-
-	log.WithFields(log.Fields{"ses": ses, "tokens": tokens, "command": "NLST", "len(files)": len(files)}).Info("session::Session::processNLST method after ses.fileProvider.List()")
-
-	// prepare directory listing
-	buf := new(bytes.Buffer)
-	for _, file := range files {
-		str := fmt.Sprintf("%s\r\n", file.Name())
-		buf.WriteString(str)
-	}
-
-	dc := ses.lastDataChanneler
-	ses.lastDataChanneler = nil // dc in use!
-
-	log.WithFields(log.Fields{"ses": ses, "tokens": tokens, "command": "NLST", "len(files)": len(files)}).Info("session::Session::processNLST method after ses.lastDataChanneler = nil")
-
-	dc.Sink(func(w io.Writer, r io.Reader) error {
-		defer dc.Close()
-
-		log.WithFields(log.Fields{"w": w, "string(buf.Bytes())": string(buf.Bytes())}).Debug("session::Session::processNLST::anonymous sending directory list")
-
-		ses.sendStatement("150 Here comes the directory listing.")
-
-		_, err := w.Write(buf.Bytes())
-
-		if err != nil {
-			ses.sendStatement(fmt.Sprintf("550 Directory listing error: %s", err))
-			return err
-		}
-
-		ses.sendStatement("226 Directory send OK.")
-		return nil
-	})
+	ses.sendListing("NLST", l)
 
 	log.WithFields(log.Fields{"ses": ses, "tokens": tokens, "command": "NLST"}).Info("session::Session::processNLST method end with success")
 	return false
@@ -383,6 +449,15 @@ func (ses *Session) processUSER(tokens []string) bool {
 	}
 
 	ses.id.SetUsername(tokens[1])
+
+	if ses.clientCertMode == ClientCertSufficient {
+		banned := ses.loginTracker != nil && (ses.loginTracker.IsIPBanned(ses.remoteIP()) || ses.loginTracker.IsUserBanned(tokens[1]))
+
+		if id := ses.certificateIdentity(tokens[1]); id != nil && !banned {
+			return ses.login(id, fmt.Sprintf("232 User %s logged in, authorized by security data exchange.", id.Username()))
+		}
+	}
+
 	ses.sendStatement(fmt.Sprintf("331 Password required for %s.", ses.id.Username()))
 	return false
 }
@@ -395,19 +470,125 @@ func (ses *Session) processPASS(tokens []string) bool {
 	}
 
 	password := tokens[1]
+	username := ses.id.Username()
+	ip := ses.remoteIP()
 
-	if !ses.authFunc(ses.id.Username(), password) {
+	if ses.loginTracker != nil && ses.loginTracker.IsIPBanned(ip) {
+		ses.sendStatement("421 Too many failed logins, closing control connection.")
+		return true
+	}
+
+	var id identity.Identity
+	// a banned user is rejected without even checking the password
+	if ses.loginTracker == nil || !ses.loginTracker.IsUserBanned(username) {
+		id = ses.authenticate(username, password)
+	}
+
+	if id != nil && ses.clientCertMode == ClientCertRequired && ses.certificateIdentity(username) == nil {
+		log.WithFields(log.Fields{"ses": ses, "username": username, "ip": ip}).Warn("session::Session::processPASS valid password but missing or wrong client certificate")
+		id = nil
+	}
+
+	if id == nil {
 		ses.id.SetAuthenticated(false)
 		ses.id.SetUsername("")
+		ses.failedLogins++
+		ses.metrics.Login(false)
+
+		if ses.loginTracker != nil {
+			log.WithFields(log.Fields{"ses": ses, "username": username, "ip": ip, "failedLogins": ses.failedLogins}).Warn("session::Session::processPASS login failed")
+
+			ses.loginTracker.Failure(ip, username)
+			time.Sleep(ses.loginTracker.Delay(ses.failedLogins))
+
+			if ses.loginTracker.TooManyAttempts(ses.failedLogins) {
+				ses.sendStatement("421 Too many failed logins, closing control connection.")
+				return true
+			}
+		}
+
 		ses.sendStatement("530 Password Rejected")
 		return false
 	}
 
+	if ses.loginTracker != nil {
+		ses.loginTracker.Success(ip, username)
+	}
+
+	return ses.login(id, fmt.Sprintf("230 User %s logged in.", id.Username()))
+}
+
+// login completes the authentication of id
+// (already validated) checking the IP rules and
+// the concurrent logins limit. reply is sent on success.
+func (ses *Session) login(id identity.Identity, reply string) bool {
+	ip := ses.remoteIP()
+
+	if !ses.allowedFrom(id) {
+		log.WithFields(log.Fields{"ses": ses, "username": id.Username(), "ip": ip}).Warn("session::Session::login login not allowed from this address")
+		ses.id.SetAuthenticated(false)
+		ses.id.SetUsername("")
+		ses.metrics.Login(false)
+		ses.sendStatement("530 Login not allowed from this address")
+		return false
+	}
+
+	e := ses.newEvent(EventLogin, "")
+	e.Identity = id
+	e.Username = id.Username()
+	if err := ses.beforeEvent(e); err != nil {
+		ses.id.SetAuthenticated(false)
+		ses.id.SetUsername("")
+		ses.metrics.Login(false)
+		ses.sendStatement(fmt.Sprintf("530 Login denied: %s", err))
+		return false
+	}
+
+	// a new login in the same session replaces the previous one
+	ses.releaseLogin()
+
+	if ses.loginCounter != nil {
+		if !ses.loginCounter.Acquire(id.Username()) {
+			ses.id.SetAuthenticated(false)
+			ses.sendStatement(fmt.Sprintf("421 Too many sessions for user %s, closing control connection.", id.Username()))
+			return true
+		}
+		ses.loggedUser = id.Username()
+	}
+
+	ses.id = id
 	ses.id.SetAuthenticated(true)
-	ses.sendStatement(fmt.Sprintf("230 User %s logged in.", ses.id.Username()))
+
+	if bl, ok := ses.id.(identity.BandwidthLimited); ok {
+		// SetBandwidthLimits is called by the server go routine
+		ses.limitsMutex.Lock()
+		ses.identityLimits = true
+		ses.downloadLimiter.SetRate(bl.DownloadLimit())
+		ses.uploadLimiter.SetRate(bl.UploadLimit())
+		ses.limitsMutex.Unlock()
+	}
+
+	ses.metrics.Login(true)
+	ses.sendStatement(reply)
+	ses.afterEvent(e)
 	return false
 }
 
+// authenticate validates the credentials with either the
+// IdentityAuthenticatorFunc (if set) or the AuthenticatorFunc.
+// It returns nil if the credentials are invalid.
+func (ses *Session) authenticate(username, password string) identity.Identity {
+	if ses.identityAuthFunc != nil {
+		return ses.identityAuthFunc(username, password)
+	}
+
+	if !ses.authFunc(username, password) {
+		return nil
+	}
+
+	return ses.id
+}
+
 func (ses *Session) processPASV(tokens []string) bool {
 	log.WithFields(log.Fields{"ses": ses, "tokens": tokens, "command": "PASV"}).Info("session::Session::processPASV method begin")
 	ip, err := getLocalIP()
@@ -474,10 +655,69 @@ func (ses *Session) processTYPE(tokens []string) bool {
 		return false
 	}
 
-	ses.sendStatement(fmt.Sprintf("200 Type set to %s", strings.ToUpper(tokens[1])))
+	// only the non-print format is supported for ASCII
+	if len(tokens) > 2 && strings.ToLower(tokens[2]) != "n" {
+		ses.sendStatement(fmt.Sprintf("504 Format %s is not supported", tokens[2]))
+		return false
+	}
+
+	ses.transferType = strings.ToUpper(tokens[1])
+
+	ses.sendStatement(fmt.Sprintf("200 Type set to %s", ses.transferType))
 	return false
 }
 
+// transferTypeName returns the transfer type
+// as shown in the 150 replies
+func (ses *Session) transferTypeName() string {
+	if ses.transferType == typeASCII {
+		return "ASCII"
+	}
+	return "BINARY"
+}
+
+func (ses *Session) processMODE(tokens []string) bool {
+	log.WithFields(log.Fields{"ses": ses, "tokens": tokens, "command": "MODE"}).Info("session::Session::processMODE method begin")
+
+	if len(tokens) < 2 {
+		ses.sendStatement("501 mode needed!")
+		return false
+	}
+
+	mode := strings.ToUpper(tokens[1])
+	if mode != modeStream && mode != modeDeflate {
+		ses.sendStatement(fmt.Sprintf("504 Mode S and Z are the only one supported. %s is not supported at this time", tokens[1]))
+		return false
+	}
+
+	ses.transferMode = mode
+
+	ses.sendStatement(fmt.Sprintf("200 Mode set to %s", ses.transferMode))
+	return false
+}
+
+// optsMODE handles OPTS MODE Z LEVEL <level>
+func (ses *Session) optsMODE(tokens []string) {
+	if len(tokens) < 3 || strings.ToUpper(tokens[2]) != modeDeflate {
+		ses.sendStatement("501 only MODE Z options are supported")
+		return
+	}
+
+	if len(tokens) < 5 || strings.ToUpper(tokens[3]) != "LEVEL" {
+		ses.sendStatement(fmt.Sprintf("200 MODE Z LEVEL %d", ses.deflateLevel))
+		return
+	}
+
+	level, err := strconv.Atoi(tokens[4])
+	if err != nil || level < zlib.NoCompression || level > zlib.BestCompression {
+		ses.sendStatement(fmt.Sprintf("501 invalid compression level %s", tokens[4]))
+		return
+	}
+
+	ses.deflateLevel = level
+	ses.sendStatement(fmt.Sprintf("200 MODE Z LEVEL set to %d", ses.deflateLevel))
+}
+
 func (ses *Session) processSIZE(tokens []string) bool {
 	log.WithFields(log.Fields{"ses": ses, "tokens": tokens, "command": "SIZE"}).Info("session::Session::processSIZE method begin")
 
@@ -486,6 +726,12 @@ func (ses *Session) processSIZE(tokens []string) bool {
 		return false
 	}
 
+	// the size on the wire depends on the line endings, see RFC 3659 section 4
+	if ses.transferType == typeASCII {
+		ses.sendStatement("550 SIZE not allowed in ASCII mode")
+		return false
+	}
+
 	file := clearPath(strings.Join(tokens[1:], " "))
 
 	f, err := ses.fileProvider.Get(file)
@@ -511,6 +757,13 @@ func (ses *Session) processMKD(tokens []string) bool {
 	}
 
 	path := strings.Join(tokens[1:], " ")
+
+	e := ses.newEvent(EventMkdir, ses.absPath(path))
+	if err := ses.beforeEvent(e); err != nil {
+		ses.sendStatement(fmt.Sprintf("550 cannot create folder %s (%s)", path, err))
+		return false
+	}
+
 	err := ses.fileProvider.CreateDirectory(path)
 
 	if err != nil {
@@ -525,6 +778,7 @@ func (ses *Session) processMKD(tokens []string) bool {
 	}
 
 	ses.sendStatement(fmt.Sprintf("257 \"%s\" directory created", dir.FullPath()))
+	ses.afterEvent(e)
 
 	return false
 }
@@ -538,17 +792,153 @@ func (ses *Session) processRMD(tokens []string) bool {
 		return false
 	}
 
-	err := ses.fileProvider.RemoveDirectory(strings.Join(tokens[1:], " "))
+	path := strings.Join(tokens[1:], " ")
+
+	e := ses.newEvent(EventRmdir, ses.absPath(path))
+	if err := ses.beforeEvent(e); err != nil {
+		ses.sendStatement(fmt.Sprintf("550 cannot delete folder %s (%s)", path, err))
+		return false
+	}
+
+	err := ses.fileProvider.RemoveDirectory(path)
 	if err != nil {
 		ses.sendStatement(fmt.Sprintf("550 cannot delete folder %s (%s)", tokens[1], err))
 		return false
 	}
 
 	ses.sendStatement("250 folder deleted successfully")
+	ses.afterEvent(e)
 
 	return false
 }
 
+func (ses *Session) processRMDA(tokens []string) bool {
+	log.WithFields(log.Fields{"ses": ses, "tokens": tokens, "command": "RMDA"}).Info("session::Session::processRMDA method begin")
+
+	rr := ses.recursiveRemover()
+	if rr == nil {
+		ses.sendStatement("502 not implemented")
+		return false
+	}
+
+	if len(tokens) < 2 {
+		ses.sendStatement("501 folder name needed")
+		return false
+	}
+
+	path := strings.Join(tokens[1:], " ")
+
+	e := ses.newEvent(EventRmdir, ses.absPath(path))
+	if err := ses.beforeEvent(e); err != nil {
+		ses.sendStatement(fmt.Sprintf("550 cannot delete folder %s (%s)", path, err))
+		return false
+	}
+
+	err := rr.RemoveDirectoryRecursive(path)
+	if err != nil {
+		ses.sendStatement(fmt.Sprintf("550 cannot delete folder %s (%s)", path, err))
+		return false
+	}
+
+	ses.sendStatement("250 folder tree deleted successfully")
+	ses.afterEvent(e)
+
+	return false
+}
+
+// recursiveRemover returns the fs.RecursiveRemover
+// capability if enabled and supported by the FileProvider,
+// nil otherwise
+func (ses *Session) recursiveRemover() fs.RecursiveRemover {
+	if !ses.allowRecursiveDelete {
+		return nil
+	}
+
+	if _, ok := fs.Unwrap(ses.fileProvider).(fs.RecursiveRemover); !ok {
+		return nil
+	}
+
+	return ses.fileProvider.(fs.RecursiveRemover)
+}
+
+func (ses *Session) processRNFR(tokens []string) bool {
+	log.WithFields(log.Fields{"ses": ses, "tokens": tokens, "command": "RNFR"}).Info("session::Session::processRNFR method begin")
+
+	if ses.renamer() == nil {
+		ses.sendStatement("502 not implemented")
+		return false
+	}
+
+	if len(tokens) < 2 {
+		ses.sendStatement("501 file name needed")
+		return false
+	}
+
+	path := strings.Join(tokens[1:], " ")
+
+	if _, err := ses.fileProvider.Get(path); err != nil {
+		ses.sendStatement(fmt.Sprintf("550 cannot rename %s (%s)", path, err))
+		return false
+	}
+
+	ses.renameFrom = path
+	ses.sendStatement("350 Ready for RNTO.")
+
+	return false
+}
+
+func (ses *Session) processRNTO(tokens []string) bool {
+	log.WithFields(log.Fields{"ses": ses, "tokens": tokens, "command": "RNTO"}).Info("session::Session::processRNTO method begin")
+
+	from := ses.renameFrom
+	ses.renameFrom = ""
+
+	rn := ses.renamer()
+	if rn == nil {
+		ses.sendStatement("502 not implemented")
+		return false
+	}
+
+	if from == "" {
+		ses.sendStatement("503 Bad sequence of commands, use RNFR first.")
+		return false
+	}
+
+	if len(tokens) < 2 {
+		ses.sendStatement("501 file name needed")
+		return false
+	}
+
+	to := strings.Join(tokens[1:], " ")
+
+	e := ses.newEvent(EventRename, ses.absPath(from))
+	e.NewPath = ses.absPath(to)
+	if err := ses.beforeEvent(e); err != nil {
+		ses.sendStatement(fmt.Sprintf("550 cannot rename %s (%s)", from, err))
+		return false
+	}
+
+	if err := rn.Rename(from, to); err != nil {
+		ses.sendStatement(fmt.Sprintf("550 cannot rename %s (%s)", from, err))
+		return false
+	}
+
+	ses.sendStatement("250 Rename successful.")
+	ses.afterEvent(e)
+
+	return false
+}
+
+// renamer returns the fs.Renamer capability
+// of the FileProvider, nil if not supported
+func (ses *Session) renamer() fs.Renamer {
+	if _, ok := fs.Unwrap(ses.fileProvider).(fs.Renamer); !ok {
+		return nil
+	}
+
+	return ses.fileProvider.(fs.Renamer)
+}
+
 func (ses *Session) processDELE(tokens []string) bool {
 	log.WithFields(log.Fields{"ses": ses, "tokens": tokens, "command": "DELE"}).Info("session::Session::processDELE method begin")
 
@@ -558,12 +948,20 @@ func (ses *Session) processDELE(tokens []string) bool {
 		return false
 	}
 
-	f, err := ses.fileProvider.Get(strings.Join(tokens[1:], " "))
+	name := strings.Join(tokens[1:], " ")
+	f, err := ses.fileProvider.Get(name)
 	if err != nil {
 		ses.sendStatement(fmt.Sprintf("550 cannot delete file %s (%s)", tokens[1], err))
 		return false
 	}
 
+	e := ses.newEvent(EventDelete, ses.absPath(name))
+	e.Size = f.Size()
+	if err := ses.beforeEvent(e); err != nil {
+		ses.sendStatement(fmt.Sprintf("550 cannot delete file %s (%s)", tokens[1], err))
+		return false
+	}
+
 	err = f.Delete()
 	if err != nil {
 		ses.sendStatement(fmt.Sprintf("550 cannot delete file %s (%s)", tokens[1], err))
@@ -571,6 +969,7 @@ func (ses *Session) processDELE(tokens []string) bool {
 	}
 
 	ses.sendStatement("200 file delete successfully")
+	ses.afterEvent(e)
 
 	return false
 }
@@ -598,7 +997,7 @@ func (ses *Session) processREST(tokens []string) bool {
 func (ses *Session) processAUTH(tokens []string) bool {
 	log.WithFields(log.Fields{"ses": ses, "tokens": tokens, "command": "AUTH"}).Info("session::Session::processAUTH method begin")
 
-	if ses.cert == nil || ses.conn.IsSecure() { // one does not need AUTH if is already encrypted
+	if ses.tlsConfig == nil || ses.conn.IsSecure() { // one does not need AUTH if is already encrypted
 		ses.sendStatement("502 not supported")
 		return false
 	}
@@ -620,14 +1019,81 @@ func (ses *Session) processAUTH(tokens []string) bool {
 		return false
 	}
 
+	// a new security exchange resets the data protection (RFC 4217)
+	ses.pbszSet = false
+	ses.dataChannelEncryption = false
+
 	return false
 }
 
+func (ses *Session) processPBSZ(tokens []string) bool {
+	log.WithFields(log.Fields{"ses": ses, "tokens": tokens, "command": "PBSZ"}).Info("session::Session::processPBSZ method begin")
+
+	if !ses.conn.IsSecure() {
+		ses.sendStatement("503 PBSZ requires AUTH TLS first")
+		return false
+	}
+
+	if len(tokens) < 2 {
+		ses.sendStatement("501 must specify buffer size!")
+		return false
+	}
+
+	if _, err := strconv.ParseUint(tokens[1], 10, 32); err != nil {
+		ses.sendStatement(fmt.Sprintf("501 invalid buffer size %s", tokens[1]))
+		return false
+	}
+
+	// TLS is a streaming protocol: the buffer size is always 0
+	ses.pbszSet = true
+	ses.sendStatement("200 PBSZ=0")
+	return false
+}
+
+func (ses *Session) processCCC(tokens []string) bool {
+	log.WithFields(log.Fields{"ses": ses, "tokens": tokens, "command": "CCC"}).Info("session::Session::processCCC method begin")
+
+	if !ses.conn.IsSecure() {
+		ses.sendStatement("533 Command channel is not protected")
+		return false
+	}
+
+	if !ses.cccAllowed() {
+		ses.sendStatement("534 Request denied for policy reasons")
+		return false
+	}
+
+	ses.sendStatement("200 Command channel cleared, TLS shut down")
+
+	if err := ses.conn.SwitchToPlain(); err != nil {
+		log.WithFields(log.Fields{"ses": ses, "err": err}).Warn("session::Session::processCCC TLS shutdown failed, closing control connection")
+		return true
+	}
+
+	return false
+}
+
+// cccAllowed returns true if the control connection
+// can go back to plain text: it must be an explicit
+// (AUTH TLS) one and the TLSPolicy must allow it
+func (ses *Session) cccAllowed() bool {
+	if ses.tlsPolicy.RequireControl && !ses.plaintextAllowed(ses.id.Username()) {
+		return false
+	}
+
+	return ses.conn.IsSecure() && !ses.implicitTLS
+}
+
 func (ses *Session) processPROT(tokens []string) bool {
 	log.WithFields(log.Fields{"ses": ses, "tokens": tokens, "command": "PROT"}).Info("session::Session::processPROT method begin")
 
 	if !ses.conn.IsSecure() { // PROT needs command channel encryption in place
-		ses.sendStatement("502 not supported")
+		ses.sendStatement("503 PROT requires AUTH TLS first")
+		return false
+	}
+
+	if !ses.pbszSet {
+		ses.sendStatement("503 PBSZ must be issued before PROT")
 		return false
 	}
 
@@ -648,6 +1114,11 @@ func (ses *Session) processPROT(tokens []string) bool {
 		return false
 	}
 	if protLevel == "C" {
+		if ses.dataTLSRequired() {
+			ses.sendStatement("534 Request denied for policy reasons, use PROT P.")
+			return false
+		}
+
 		ses.dataChannelEncryption = false
 		if ses.lastDataChanneler != nil {
 			ses.lastDataChanneler.SetEncrypted(false)
@@ -656,6 +1127,11 @@ func (ses *Session) processPROT(tokens []string) bool {
 		return false
 	}
 
-	ses.sendStatement(fmt.Sprintf("550 %s is not supported", protLevel))
+	if protLevel == "S" || protLevel == "E" {
+		ses.sendStatement(fmt.Sprintf("536 PROT %s not supported by TLS", protLevel))
+		return false
+	}
+
+	ses.sendStatement(fmt.Sprintf("504 PROT %s is not a valid protection level", protLevel))
 	return false
 }
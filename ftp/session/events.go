@@ -0,0 +1,192 @@
+package session
+
+import (
+	"fmt"
+	"path"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/mindflavor/ftpserver2/identity"
+)
+
+// EventType identifies the session events
+type EventType int
+
+// Session events
+const (
+	EventLogin EventType = iota
+	EventLogout
+	EventUploadComplete
+	EventDownloadComplete
+	EventDelete
+	EventRename
+	EventMkdir
+	EventRmdir
+)
+
+var eventNames = []string{
+	"Login",
+	"Logout",
+	"UploadComplete",
+	"DownloadComplete",
+	"Delete",
+	"Rename",
+	"Mkdir",
+	"Rmdir",
+}
+
+func (t EventType) String() string {
+	if t < 0 || int(t) >= len(eventNames) {
+		return fmt.Sprintf("EventType(%d)", int(t))
+	}
+	return eventNames[t]
+}
+
+// Event is passed to the EventHandlers. Path is
+// absolute (NewPath is the destination of a rename)
+// and Size is the number of bytes transferred
+// (or the size of the deleted file).
+type Event struct {
+	Type       EventType
+	Time       time.Time
+	Identity   identity.Identity
+	Username   string
+	RemoteAddr string
+	TLS        bool
+	Path       string
+	NewPath    string
+	Size       int64
+}
+
+// EventHandler handles a session event. The error
+// returned by a HookSync handler vetoes the operation.
+type EventHandler func(e Event) error
+
+// HookMode tells how an EventHandler is run
+type HookMode int
+
+const (
+	// HookAsync handlers run in their own go func once
+	// the operation succeeded. Their errors are only logged.
+	HookAsync HookMode = iota
+	// HookSync handlers run in the session go func before
+	// the operation is carried out (Login, Delete, Rename,
+	// Mkdir, Rmdir) or before its final reply is sent
+	// (UploadComplete: the uploaded file is removed) and
+	// can veto it returning an error. The Logout and
+	// DownloadComplete errors are only logged.
+	HookSync
+)
+
+// Hooks holds the EventHandlers
+// shared by the sessions
+type Hooks struct {
+	mutex sync.RWMutex
+	sync  map[EventType][]EventHandler
+	async map[EventType][]EventHandler
+}
+
+// NewHooks creates an empty Hooks
+func NewHooks() *Hooks {
+	return &Hooks{
+		sync:  make(map[EventType][]EventHandler),
+		async: make(map[EventType][]EventHandler),
+	}
+}
+
+// Add registers f for the events of type t
+func (h *Hooks) Add(t EventType, mode HookMode, f EventHandler) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if mode == HookSync {
+		h.sync[t] = append(h.sync[t], f)
+	} else {
+		h.async[t] = append(h.async[t], f)
+	}
+}
+
+// before runs the HookSync handlers of e. It
+// stops at the first error and returns it.
+func (h *Hooks) before(e Event) error {
+	if h == nil {
+		return nil
+	}
+
+	h.mutex.RLock()
+	handlers := h.sync[e.Type]
+	h.mutex.RUnlock()
+
+	for _, f := range handlers {
+		if err := f(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// after starts the HookAsync handlers of e
+func (h *Hooks) after(e Event) {
+	if h == nil {
+		return
+	}
+
+	h.mutex.RLock()
+	handlers := h.async[e.Type]
+	h.mutex.RUnlock()
+
+	for _, f := range handlers {
+		go func(f EventHandler) {
+			if err := f(e); err != nil {
+				log.WithFields(log.Fields{"event": e.Type, "path": e.Path, "err": err}).Warn("session::Hooks::after asynchronous hook failed")
+			}
+		}(f)
+	}
+}
+
+// SetHooks sets the EventHandlers called
+// by the session. It can be nil.
+func (ses *Session) SetHooks(h *Hooks) {
+	ses.hooks = h
+}
+
+// newEvent fills the identity and session
+// information of an event of type t on p
+func (ses *Session) newEvent(t EventType, p string) Event {
+	return Event{
+		Type:       t,
+		Time:       time.Now(),
+		Identity:   ses.id,
+		Username:   ses.id.Username(),
+		RemoteAddr: ses.conn.RemoteAddr().String(),
+		TLS:        ses.conn.IsSecure(),
+		Path:       p,
+	}
+}
+
+// beforeEvent runs the HookSync handlers of e logging the veto
+func (ses *Session) beforeEvent(e Event) error {
+	err := ses.hooks.before(e)
+	if err != nil {
+		log.WithFields(log.Fields{"ses": ses, "event": e.Type, "path": e.Path, "err": err}).Info("session::Session::beforeEvent operation vetoed by hook")
+	}
+	return err
+}
+
+// afterEvent starts the HookAsync handlers of e
+func (ses *Session) afterEvent(e Event) {
+	ses.hooks.after(e)
+}
+
+// absPath returns the absolute form of the
+// path p relative to the current directory
+func (ses *Session) absPath(p string) string {
+	if p == "" {
+		return ses.fileProvider.CurrentDirectory()
+	}
+	if p[0] == '/' {
+		return path.Clean(p)
+	}
+	return path.Join(ses.fileProvider.CurrentDirectory(), p)
+}
@@ -0,0 +1,123 @@
+package session
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newEventsTestSession(t *testing.T) (*Session, *fakeConn, *Hooks, string) {
+	ses, conn, dir := newFSTestSession(t)
+	hooks := NewHooks()
+	ses.SetHooks(hooks)
+
+	return ses, conn, hooks, dir
+}
+
+func TestLoginHook(t *testing.T) {
+	ses, conn, hooks, dir := newEventsTestSession(t)
+	defer os.RemoveAll(dir)
+
+	hooks.Add(EventLogin, HookSync, func(e Event) error {
+		if e.Username == "blocked" {
+			return errors.New("account suspended")
+		}
+		return nil
+	})
+
+	ses.processUSER([]string{"USER", "blocked"})
+	ses.processPASS([]string{"PASS", "secret"})
+	assert.Equal(t, "530 Login denied: account suspended", conn.lastReply())
+	assert.False(t, ses.id.Authenticated())
+
+	ses.processUSER([]string{"USER", "alice"})
+	ses.processPASS([]string{"PASS", "secret"})
+	assert.True(t, strings.HasPrefix(conn.lastReply(), "230"))
+
+	logout := make(chan Event, 1)
+	hooks.Add(EventLogout, HookAsync, func(e Event) error {
+		logout <- e
+		return nil
+	})
+	ses.Close()
+
+	select {
+	case e := <-logout:
+		assert.Equal(t, "alice", e.Username)
+		assert.Equal(t, "10.0.0.1:4000", e.RemoteAddr)
+	case <-time.After(5 * time.Second):
+		t.Fatal("logout hook not called")
+	}
+}
+
+func TestDirectoryHooks(t *testing.T) {
+	ses, conn, hooks, dir := newEventsTestSession(t)
+	defer os.RemoveAll(dir)
+
+	var events []Event
+	hooks.Add(EventMkdir, HookSync, func(e Event) error {
+		events = append(events, e)
+		if e.Path == "/forbidden" {
+			return errors.New("reserved name")
+		}
+		return nil
+	})
+	created := make(chan Event, 1)
+	hooks.Add(EventMkdir, HookAsync, func(e Event) error {
+		created <- e
+		return nil
+	})
+
+	ses.processMKD([]string{"MKD", "forbidden"})
+	assert.True(t, strings.HasPrefix(conn.lastReply(), "550"))
+	_, err := os.Stat(filepath.Join(dir, "forbidden"))
+	assert.True(t, os.IsNotExist(err))
+
+	ses.processMKD([]string{"MKD", "data"})
+	assert.True(t, strings.HasPrefix(conn.lastReply(), "257"))
+	assert.Len(t, events, 2)
+	assert.Equal(t, "/data", (<-created).Path)
+
+	var renamed Event
+	hooks.Add(EventRename, HookSync, func(e Event) error {
+		renamed = e
+		return nil
+	})
+
+	ses.processRNTO([]string{"RNTO", "archive"})
+	assert.True(t, strings.HasPrefix(conn.lastReply(), "503"))
+
+	ses.processRNFR([]string{"RNFR", "data"})
+	assert.True(t, strings.HasPrefix(conn.lastReply(), "350"))
+	ses.processRNTO([]string{"RNTO", "archive"})
+	assert.Equal(t, "250 Rename successful.", conn.lastReply())
+	assert.Equal(t, "/data", renamed.Path)
+	assert.Equal(t, "/archive", renamed.NewPath)
+
+	_, err = os.Stat(filepath.Join(dir, "archive"))
+	assert.NoError(t, err)
+}
+
+func TestDeleteHookVeto(t *testing.T) {
+	ses, conn, hooks, dir := newEventsTestSession(t)
+	defer os.RemoveAll(dir)
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "keep.txt"), []byte("hello"), 0644))
+
+	hooks.Add(EventDelete, HookSync, func(e Event) error {
+		assert.Equal(t, int64(5), e.Size)
+		return errors.New("file is under retention")
+	})
+
+	ses.processDELE([]string{"DELE", "keep.txt"})
+	assert.True(t, strings.HasPrefix(conn.lastReply(), "550"))
+
+	_, err := os.Stat(filepath.Join(dir, "keep.txt"))
+	assert.NoError(t, err)
+}
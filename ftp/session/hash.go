@@ -0,0 +1,169 @@
+package session
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/mindflavor/ftpserver2/ftp/fs"
+)
+
+// processHASH implements the HASH command as described in
+// draft-bryan-ftpext-hash. The algorithm is selected with OPTS HASH.
+func (ses *Session) processHASH(tokens []string) bool {
+	log.WithFields(log.Fields{"ses": ses, "tokens": tokens, "command": "HASH"}).Info("session::Session::processHASH method begin")
+
+	if len(tokens) < 2 {
+		ses.sendStatement("501 object needed!")
+		return false
+	}
+
+	path := strings.Join(tokens[1:], " ")
+
+	f, err := ses.fileProvider.Get(clearPath(path))
+	if err != nil {
+		ses.sendStatement(fmt.Sprintf("550 Could not get file: %s.", err))
+		return false
+	}
+
+	if f.IsDirectory() {
+		ses.sendStatement(fmt.Sprintf("553 %s is a directory.", path))
+		return false
+	}
+
+	digest, err := ses.computeHash(f, ses.hashAlgorithm, 0, -1)
+	if err != nil {
+		log.WithFields(log.Fields{"ses": ses, "tokens": tokens, "err": err}).Warn("session::Session::processHASH computeHash failed")
+		ses.sendStatement(fmt.Sprintf("550 Could not compute hash: %s.", err))
+		return false
+	}
+
+	ses.sendStatement(fmt.Sprintf("213 %s 0-%d %s %s", ses.hashAlgorithm, f.Size(), hex.EncodeToString(digest), path))
+	return false
+}
+
+func (ses *Session) processXCRC(tokens []string) bool {
+	return ses.processXHash(tokens, fs.HashCRC32)
+}
+
+func (ses *Session) processXMD5(tokens []string) bool {
+	return ses.processXHash(tokens, fs.HashMD5)
+}
+
+func (ses *Session) processXSHA1(tokens []string) bool {
+	return ses.processXHash(tokens, fs.HashSHA1)
+}
+
+func (ses *Session) processXSHA256(tokens []string) bool {
+	return ses.processXHash(tokens, fs.HashSHA256)
+}
+
+// processXHash handles the de-facto XCRC, XMD5, XSHA1 and XSHA256
+// commands. The syntax is <cmd> <path> [<start> <end>].
+func (ses *Session) processXHash(tokens []string, algorithm string) bool {
+	log.WithFields(log.Fields{"ses": ses, "tokens": tokens, "command": tokens[0], "algorithm": algorithm}).Info("session::Session::processXHash method begin")
+
+	if len(tokens) < 2 {
+		ses.sendStatement("501 object needed!")
+		return false
+	}
+
+	path := tokens[1:]
+	start, end := int64(0), int64(-1)
+
+	// optional range: the last two tokens must be numbers
+	if len(tokens) >= 4 {
+		s, errS := strconv.ParseInt(tokens[len(tokens)-2], 10, 64)
+		e, errE := strconv.ParseInt(tokens[len(tokens)-1], 10, 64)
+		if errS == nil && errE == nil {
+			if s < 0 || e < s {
+				ses.sendStatement("501 invalid range")
+				return false
+			}
+			start, end = s, e
+			path = tokens[1 : len(tokens)-2]
+		}
+	}
+
+	f, err := ses.fileProvider.Get(clearPath(strings.Join(path, " ")))
+	if err != nil {
+		ses.sendStatement(fmt.Sprintf("550 Could not get file: %s.", err))
+		return false
+	}
+
+	if f.IsDirectory() {
+		ses.sendStatement(fmt.Sprintf("550 %s is a directory.", strings.Join(path, " ")))
+		return false
+	}
+
+	digest, err := ses.computeHash(f, algorithm, start, end)
+	if err != nil {
+		log.WithFields(log.Fields{"ses": ses, "tokens": tokens, "err": err}).Warn("session::Session::processXHash computeHash failed")
+		ses.sendStatement(fmt.Sprintf("550 Could not compute hash: %s.", err))
+		return false
+	}
+
+	ses.sendStatement(fmt.Sprintf("250 %s", strings.ToUpper(hex.EncodeToString(digest))))
+	return false
+}
+
+// optsHASH handles OPTS HASH [<algorithm>]
+func (ses *Session) optsHASH(tokens []string) {
+	if len(tokens) < 3 {
+		ses.sendStatement(fmt.Sprintf("200 %s", ses.hashAlgorithm))
+		return
+	}
+
+	algorithm, err := fs.ParseHashAlgorithm(tokens[2])
+	if err != nil {
+		ses.sendStatement("504 Unknown algorithm, current selection not changed")
+		return
+	}
+
+	ses.hashAlgorithm = algorithm
+	ses.sendStatement(fmt.Sprintf("200 %s", ses.hashAlgorithm))
+}
+
+// computeHash returns the digest of the file. If end is negative
+// the whole file (from start) is hashed. The fs.Hasher
+// capability is used, if available, for whole file digests.
+func (ses *Session) computeHash(f fs.File, algorithm string, start, end int64) ([]byte, error) {
+	if hasher, ok := f.(fs.Hasher); ok && start == 0 && end < 0 {
+		return hasher.Hash(algorithm)
+	}
+
+	r, err := f.Read(start)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	if end < 0 {
+		return fs.HashReader(r, algorithm)
+	}
+
+	return fs.HashReader(io.LimitReader(r, end-start), algorithm)
+}
+
+// hashFeature returns the HASH FEAT line with the
+// currently selected algorithm marked by *
+func (ses *Session) hashFeature() string {
+	var buf bytes.Buffer
+	buf.WriteString("HASH ")
+
+	for i, algo := range fs.HashAlgorithms {
+		if i > 0 {
+			buf.WriteString(";")
+		}
+		buf.WriteString(algo)
+		if algo == ses.hashAlgorithm {
+			buf.WriteString("*")
+		}
+	}
+
+	return buf.String()
+}
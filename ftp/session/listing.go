@@ -0,0 +1,552 @@
+package session
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/mindflavor/ftpserver2/ftp/fs"
+	"github.com/mindflavor/ftpserver2/ftp/metrics"
+	"github.com/mindflavor/ftpserver2/identity"
+)
+
+// defaultOwner is shown as owner and group of
+// the files whose ownership is unknown
+const defaultOwner = "ftp"
+
+// SetMaskOwner hides the real owner and group of
+// the files in LIST, MLSD and MLST: the logged in
+// username is shown instead.
+func (ses *Session) SetMaskOwner(mask bool) {
+	ses.maskOwner = mask
+}
+
+// ownership returns the owner, group and link count
+// shown for f (nil means unknown)
+func (ses *Session) ownership(f fs.File) (string, string, int) {
+	owner, group, links := "", "", 1
+	if o, ok := f.(fs.Owned); ok {
+		owner, group, links = o.Owner(), o.Group(), o.Links()
+	}
+
+	if ses.maskOwner {
+		return ses.id.Username(), ses.id.Username(), links
+	}
+
+	if owner == "" {
+		owner = defaultOwner
+	}
+	if group == "" {
+		group = defaultOwner
+	}
+
+	return owner, group, links
+}
+
+// listLine formats f, shown as name,
+// as a LIST (ls -l) line
+func (ses *Session) listLine(f fs.File, name string) string {
+	var date string
+	diff := time.Now().Sub(f.ModTime())
+	if diff.Hours() > 24*30*6 {
+		date = fmt.Sprintf("%3.3s %2d  %04d", f.ModTime().Month(), f.ModTime().Day(), f.ModTime().Year())
+	} else {
+		date = fmt.Sprintf("%3.3s %2d %02d:%02d", f.ModTime().Month(), f.ModTime().Day(), f.ModTime().Hour(), f.ModTime().Minute())
+	}
+
+	owner, group, links := ses.ownership(f)
+	return fmt.Sprintf("%s %3d %-10s %-10s %10d %s %s\r\n", f.Mode(), links, owner, group, f.Size(), date, name)
+}
+
+// dotLines returns the LIST lines
+// of the . and .. directories
+func (ses *Session) dotLines() string {
+	owner, group, _ := ses.ownership(nil)
+	return fmt.Sprintf("%s %3d %-10s %-10s %10d Jan  02  2006 %s\r\n", "drwxrwxrwx", 2, owner, group, 0, ".") +
+		fmt.Sprintf("%s %3d %-10s %-10s %10d Jan  02  2006 %s\r\n", "drwxrwxrwx", 2, owner, group, 0, "..")
+}
+
+// facts returns the RFC 3659 facts of f
+// (MLSD and MLST) followed by a space
+func (ses *Session) facts(f fs.File) string {
+	buf := new(bytes.Buffer)
+
+	if f.IsDirectory() {
+		buf.WriteString("type=dir;")
+	} else {
+		buf.WriteString("type=file;")
+		buf.WriteString(fmt.Sprintf("size=%d;", f.Size()))
+	}
+
+	buf.WriteString(fmt.Sprintf("modify=%s;", f.ModTime().UTC().Format(utimeLayout)))
+	buf.WriteString(fmt.Sprintf("perm=%s;", ses.perm(f)))
+
+	if mode := unixMode(f.Mode()); mode != "" {
+		buf.WriteString(fmt.Sprintf("UNIX.mode=%s;", mode))
+	}
+
+	owner, group, _ := ses.ownership(f)
+	buf.WriteString(fmt.Sprintf("UNIX.owner=%s;UNIX.group=%s; ", owner, group))
+
+	return buf.String()
+}
+
+// filePerms and dirPerms map the RFC 3659
+// perm letters to the identity permissions
+var (
+	filePerms = []struct{ letter, permission string }{
+		{"r", identity.PermissionRead},
+		{"w", identity.PermissionWrite},
+		{"d", identity.PermissionDelete},
+		{"f", identity.PermissionRename},
+	}
+	dirPerms = []struct{ letter, permission string }{
+		{"c", identity.PermissionWrite},
+		{"e", identity.PermissionList},
+		{"l", identity.PermissionList},
+		{"m", identity.PermissionMkdir},
+		{"p", identity.PermissionDelete},
+		{"d", identity.PermissionDelete},
+		{"f", identity.PermissionRename},
+	}
+)
+
+// perm returns the RFC 3659 perm fact of f: the
+// letters the identity.Authorizer of the user allows
+func (ses *Session) perm(f fs.File) string {
+	perms := filePerms
+	if f.IsDirectory() {
+		perms = dirPerms
+	}
+
+	az, _ := ses.id.(identity.Authorizer)
+
+	var perm string
+	for _, p := range perms {
+		if az != nil && !az.HasPermission(p.permission) {
+			continue
+		}
+		if p.letter == "f" && ses.renamer() == nil {
+			continue
+		}
+		perm += p.letter
+	}
+	return perm
+}
+
+// unixMode converts an ls -l (or os.FileMode)
+// mode string to octal, empty if it cannot
+func unixMode(mode string) string {
+	if len(mode) < 9 {
+		return ""
+	}
+	flags, perm := mode[:len(mode)-9], mode[len(mode)-9:]
+
+	var m uint32
+	for i, c := range perm {
+		if c != '-' && c != 'S' && c != 'T' {
+			m |= 1 << uint(8-i)
+		}
+	}
+
+	if perm[2] == 's' || perm[2] == 'S' || strings.ContainsRune(flags, 'u') {
+		m |= 04000
+	}
+	if perm[5] == 's' || perm[5] == 'S' || strings.ContainsRune(flags, 'g') {
+		m |= 02000
+	}
+	if perm[8] == 't' || perm[8] == 'T' || strings.ContainsRune(flags, 't') {
+		m |= 01000
+	}
+
+	return fmt.Sprintf("%04o", m)
+}
+
+// ListLimits caps the LIST and NLST output.
+// 0 means unlimited.
+type ListLimits struct {
+	// MaxDepth is the number of subdirectory
+	// levels listed by -R
+	MaxDepth int
+	// MaxEntries is the number of entries
+	// after which the listing is truncated
+	MaxEntries int
+}
+
+// DefaultListLimits returns the limits used
+// unless SetListLimits is called
+func DefaultListLimits() ListLimits {
+	return ListLimits{
+		MaxDepth:   8,
+		MaxEntries: 50000,
+	}
+}
+
+// SetListLimits sets the LIST and NLST limits
+func (ses *Session) SetListLimits(l ListLimits) {
+	ses.listLimits = l
+}
+
+// listOptions are the ls style
+// flags of LIST and NLST
+type listOptions struct {
+	all       bool // -a: show hidden files, . and ..
+	long      bool // -l: ls -l format
+	recursive bool // -R: list the subdirectories
+	byTime    bool // -t: newest first
+	flags     bool // any flag was passed
+}
+
+// parseListArgs splits the LIST and NLST arguments
+// in the leading flags and the path. Unknown flags
+// are ignored.
+func parseListArgs(args []string) (listOptions, string) {
+	var opts listOptions
+
+	i := 0
+	for ; i < len(args) && len(args[i]) > 1 && args[i][0] == '-'; i++ {
+		opts.flags = true
+		for _, c := range args[i][1:] {
+			switch c {
+			case 'a':
+				opts.all = true
+			case 'l':
+				opts.long = true
+			case 'R':
+				opts.recursive = true
+			case 't':
+				opts.byTime = true
+			}
+		}
+	}
+
+	return opts, strings.Join(args[i:], " ")
+}
+
+// errListingTruncated stops a
+// listing over the MaxEntries limit
+var errListingTruncated = errors.New("listing truncated")
+
+// listing streams the LIST, NLST and MLSD output.
+// The target is resolved by prepare, before the data
+// connection is used, so a missing path can still be
+// refused. It works on a clone of the session
+// FileProvider: the current directory never changes.
+type listing struct {
+	ses       *Session
+	fp        fs.FileProvider
+	opts      listOptions
+	hidden    bool // show the hidden files
+	dots      bool // show . and ..
+	line      func(f fs.File, name string) string
+	w         io.Writer
+	entries   int
+	truncated bool
+
+	// the target, set by prepare
+	file    fs.File
+	dir     string
+	display string
+	pattern string
+}
+
+func (ses *Session) newListing(opts listOptions) *listing {
+	l := &listing{
+		ses:    ses,
+		fp:     ses.fileProvider.Clone(),
+		opts:   opts,
+		hidden: opts.all,
+		dots:   opts.all,
+		line:   func(f fs.File, name string) string { return name + "\r\n" },
+	}
+
+	if opts.long {
+		l.line = ses.listLine
+	}
+
+	return l
+}
+
+// prepare resolves arg: a directory (the current
+// one if empty), a file or a glob pattern in its
+// last element
+func (l *listing) prepare(arg string) error {
+	if arg == "" {
+		l.dir = l.fp.CurrentDirectory()
+		return nil
+	}
+
+	dir, pattern := path.Split(arg)
+	if strings.ContainsAny(pattern, "*?[") {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return err
+		}
+		l.display, l.pattern = dir, pattern
+		l.hidden = l.hidden || strings.HasPrefix(pattern, ".")
+		return l.prepareDirectory(dir)
+	}
+
+	if f, err := l.fp.Get(l.ses.absPath(arg)); err == nil && !f.IsDirectory() {
+		l.file, l.display = f, arg
+		return nil
+	}
+
+	l.display = arg
+	return l.prepareDirectory(arg)
+}
+
+// prepareDirectory resolves the
+// arg directory (the current one if empty)
+func (l *listing) prepareDirectory(arg string) error {
+	l.dir = l.ses.absPath(arg)
+	return l.fp.ChangeDirectory(l.dir)
+}
+
+// write streams the listing to w
+func (l *listing) write(w io.Writer) error {
+	l.w = w
+
+	var err error
+	switch {
+	case l.file != nil:
+		err = l.entry(l.file, l.display)
+	case l.pattern != "":
+		err = l.glob()
+	default:
+		err = l.directory(l.display, l.dir, 0)
+	}
+
+	if err == errListingTruncated {
+		return nil
+	}
+	return err
+}
+
+// directory lists the p directory shown as display.
+// Subdirectories are listed too with -R, up to
+// the MaxDepth limit.
+func (l *listing) directory(display, p string, depth int) error {
+	if err := l.fp.ChangeDirectory(p); err != nil {
+		return err
+	}
+
+	if l.opts.recursive {
+		if depth > 0 {
+			if _, err := io.WriteString(l.w, "\r\n"); err != nil {
+				return err
+			}
+		}
+		if display == "" {
+			display = "."
+		}
+		if _, err := io.WriteString(l.w, display+":\r\n"); err != nil {
+			return err
+		}
+	}
+
+	if l.dots && l.pattern == "" {
+		dots := ".\r\n..\r\n"
+		if l.opts.long {
+			dots = l.ses.dotLines()
+		}
+		if _, err := io.WriteString(l.w, dots); err != nil {
+			return err
+		}
+	}
+
+	recurse := l.opts.recursive && (l.ses.listLimits.MaxDepth == 0 || depth < l.ses.listLimits.MaxDepth)
+
+	// only the subdirectory names are kept, unless
+	// -t needs the whole directory to sort it
+	var subdirs []string
+	var files []fs.File
+	err := fs.ListEach(l.fp, func(f fs.File) error {
+		if !l.visible(f) {
+			return nil
+		}
+		if l.opts.byTime {
+			files = append(files, f)
+			return nil
+		}
+		if recurse && f.IsDirectory() {
+			subdirs = append(subdirs, f.Name())
+		}
+		return l.entry(f, f.Name())
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, f := range l.sort(files) {
+		if recurse && f.IsDirectory() {
+			subdirs = append(subdirs, f.Name())
+		}
+		if err := l.entry(f, f.Name()); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range subdirs {
+		sub := path.Join(p, name)
+		err := l.directory(path.Join(display, name), sub, depth+1)
+		if err == errListingTruncated {
+			return err
+		}
+		if err != nil {
+			log.WithFields(log.Fields{"ses": l.ses, "path": sub, "err": err}).Warn("session::listing::directory cannot list subdirectory")
+		}
+	}
+
+	return nil
+}
+
+// glob lists the entries of the
+// directory matching the pattern
+func (l *listing) glob() error {
+	var files []fs.File
+	err := fs.ListEach(l.fp, func(f fs.File) error {
+		if !l.visible(f) {
+			return nil
+		}
+		if ok, _ := path.Match(l.pattern, f.Name()); !ok {
+			return nil
+		}
+		if l.opts.byTime {
+			files = append(files, f)
+			return nil
+		}
+		return l.entry(f, l.display+f.Name())
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, f := range l.sort(files) {
+		if err := l.entry(f, l.display+f.Name()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// visible returns false for the
+// hidden files, unless shown
+func (l *listing) visible(f fs.File) bool {
+	return l.hidden || !strings.HasPrefix(f.Name(), ".")
+}
+
+// sort orders the files newest first
+func (l *listing) sort(files []fs.File) []fs.File {
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].ModTime().After(files[j].ModTime())
+	})
+	return files
+}
+
+// entry writes f shown as name. It returns
+// errListingTruncated once the MaxEntries
+// limit has been reached.
+func (l *listing) entry(f fs.File, name string) error {
+	if l.ses.listLimits.MaxEntries > 0 && l.entries >= l.ses.listLimits.MaxEntries {
+		log.WithFields(log.Fields{"ses": l.ses, "entries": l.entries}).Warn("session::listing::entry listing truncated")
+		l.truncated = true
+		return errListingTruncated
+	}
+	l.entries++
+
+	_, err := io.WriteString(l.w, l.line(f, name))
+	return err
+}
+
+// sendListing streams l on the pending data
+// channel with the directory listing replies
+func (ses *Session) sendListing(command string, l *listing) {
+	dc := ses.lastDataChanneler
+	ses.lastDataChanneler = nil // dc in use!
+	ses.countTransfer(dc, metrics.Download)
+	ts := ses.transferSettings()
+
+	dc.Sink(func(w io.Writer, r io.Reader) error {
+		defer dc.Close()
+
+		log.WithFields(log.Fields{"w": w, "command": command}).Debug("session::Session::sendListing::anonymous sending directory list")
+
+		w, flush, err := ts.dataWriter(w)
+		if err != nil {
+			ses.sendStatement(fmt.Sprintf("451 Could not open data stream: %s.", err))
+			return err
+		}
+
+		ses.sendStatement("150 Here comes the directory listing.")
+
+		bw := bufio.NewWriter(w)
+		err = l.write(bw)
+		if err == nil {
+			err = bw.Flush()
+		}
+		if err == nil {
+			err = flush()
+		}
+
+		if err != nil {
+			ses.sendStatement(fmt.Sprintf("550 Directory listing error: %s", err))
+			return err
+		}
+
+		log.WithFields(log.Fields{"ses": ses, "command": command, "entries": l.entries}).Info("session::Session::sendListing directory list sent")
+
+		if l.truncated {
+			ses.sendStatement(fmt.Sprintf("226 Directory send OK (truncated at %d entries).", ses.listLimits.MaxEntries))
+			return nil
+		}
+
+		ses.sendStatement("226 Directory send OK.")
+		return nil
+	})
+}
+
+func (ses *Session) processMLSD(tokens []string) bool {
+	log.WithFields(log.Fields{"ses": ses, "tokens": tokens, "command": "MLSD"}).Info("session::Session::processMLSD method begin")
+
+	l := ses.newListing(listOptions{})
+	l.hidden = true
+	l.line = func(f fs.File, name string) string {
+		return ses.facts(f) + name + "\r\n"
+	}
+
+	if err := l.prepareDirectory(strings.Join(tokens[1:], " ")); err != nil {
+		ses.sendStatement(fmt.Sprintf("451 cannot retrieve directory list: %s", err))
+		return false
+	}
+
+	ses.sendListing("MLSD", l)
+
+	log.WithFields(log.Fields{"ses": ses, "tokens": tokens, "command": "MLSD"}).Info("session::Session::processMLSD method end with success")
+	return false
+}
+
+func (ses *Session) processMLST(tokens []string) bool {
+	log.WithFields(log.Fields{"ses": ses, "tokens": tokens, "command": "MLST"}).Info("session::Session::processMLST method begin")
+
+	path := ses.fileProvider.CurrentDirectory()
+	if len(tokens) > 1 {
+		path = clearPath(strings.Join(tokens[1:], " "))
+	}
+
+	f, err := ses.fileProvider.Get(path)
+	if err != nil {
+		ses.sendStatement(fmt.Sprintf("550 Could not get file: %s.", err))
+		return false
+	}
+
+	ses.sendStatement(fmt.Sprintf("250-Listing %s\r\n %s%s\r\n250 End", path, ses.facts(f), path))
+	return false
+}
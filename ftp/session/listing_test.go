@@ -0,0 +1,222 @@
+package session
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mindflavor/ftpserver2/ftp/fs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnixMode(t *testing.T) {
+	assert.Equal(t, "0644", unixMode("-rw-r--r--"))
+	assert.Equal(t, "2777", unixMode("drwxrwsrwx"))
+	assert.Equal(t, "0755", unixMode("drwxr-xr-x"))
+	assert.Equal(t, "1777", unixMode("dtrwxrwxrwx"))
+	assert.Equal(t, "", unixMode("?"))
+}
+
+func TestListOwner(t *testing.T) {
+	ses, conn, dir := newFSTestSession(t)
+	defer os.RemoveAll(dir)
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "report.txt"), []byte("hello"), 0640))
+
+	f, err := ses.fileProvider.Get("report.txt")
+	assert.NoError(t, err)
+
+	if u, err := user.Current(); err == nil && filepath.Separator == '/' {
+		owner, _, links := ses.ownership(f)
+		assert.Equal(t, u.Username, owner)
+		assert.Equal(t, 1, links)
+		assert.Contains(t, ses.listLine(f, f.Name()), " "+u.Username+" ")
+	}
+
+	ses.dispatch([]string{"MLST", "report.txt"})
+	out := conn.out.String()
+	assert.True(t, strings.HasPrefix(out, "250-Listing report.txt\r\n type=file;size=5;modify="))
+	assert.Contains(t, out, ";UNIX.mode=0640;UNIX.owner=")
+	assert.Equal(t, "250 End", conn.lastReply())
+
+	ses.SetMaskOwner(true)
+	owner, group, _ := ses.ownership(f)
+	assert.Equal(t, "alice", owner)
+	assert.Equal(t, "alice", group)
+	assert.True(t, strings.HasPrefix(ses.listLine(f, f.Name()), "-rw-r-----   1 alice      alice               5 "))
+
+	conn.out.Reset()
+	ses.dispatch([]string{"MLST", "missing.txt"})
+	assert.True(t, strings.HasPrefix(conn.lastReply(), "550"))
+}
+
+func TestPermFact(t *testing.T) {
+	ses, _, dir := newFSTestSession(t)
+	defer os.RemoveAll(dir)
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "report.txt"), []byte("hello"), 0640))
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, "docs"), 0755))
+
+	file, err := ses.fileProvider.Get("report.txt")
+	assert.NoError(t, err)
+	docs, err := ses.fileProvider.Get("docs")
+	assert.NoError(t, err)
+
+	// no identity.Authorizer: everything is allowed
+	assert.Equal(t, "rwdf", ses.perm(file))
+	assert.Equal(t, "celmpdf", ses.perm(docs))
+
+	ses.id = readOnly{ses.id}
+	assert.Equal(t, "r", ses.perm(file))
+	assert.Equal(t, "el", ses.perm(docs))
+}
+
+func TestParseListArgs(t *testing.T) {
+	opts, arg := parseListArgs([]string{"-la", "-R", "my", "dir"})
+	assert.Equal(t, listOptions{all: true, long: true, recursive: true, flags: true}, opts)
+	assert.Equal(t, "my dir", arg)
+
+	opts, arg = parseListArgs([]string{"-t"})
+	assert.Equal(t, listOptions{byTime: true, flags: true}, opts)
+	assert.Equal(t, "", arg)
+
+	opts, arg = parseListArgs([]string{"dir"})
+	assert.False(t, opts.flags)
+	assert.Equal(t, "dir", arg)
+
+	_, arg = parseListArgs([]string{"-", "file"})
+	assert.Equal(t, "- file", arg)
+}
+
+func TestListing(t *testing.T) {
+	ses, _, dir := newFSTestSession(t)
+	defer os.RemoveAll(dir)
+
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "data", "2016", "03"), 0755))
+	for _, name := range []string{"a.csv", "b.csv", "notes.txt", ".hidden"} {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "data", name), []byte(name), 0644))
+	}
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "data", "2016", "03", "c.csv"), []byte("c"), 0644))
+	assert.NoError(t, os.Chtimes(filepath.Join(dir, "data", "b.csv"), time.Now(), time.Now().Add(time.Hour)))
+
+	list := func(opts listOptions, arg string) (string, *listing) {
+		buf := new(bytes.Buffer)
+		l := ses.newListing(opts)
+		assert.NoError(t, l.prepare(arg))
+		assert.NoError(t, l.write(buf))
+		return buf.String(), l
+	}
+
+	// the local file system streams
+	// the entries in directory order
+	lines := func(out string) []string {
+		return strings.Split(strings.TrimSuffix(out, "\r\n"), "\r\n")
+	}
+
+	out, _ := list(listOptions{}, "data")
+	assert.ElementsMatch(t, []string{"2016", "a.csv", "b.csv", "notes.txt"}, lines(out))
+
+	out, _ = list(listOptions{all: true}, "data")
+	assert.True(t, strings.HasPrefix(out, ".\r\n..\r\n"))
+	assert.ElementsMatch(t, []string{".", "..", ".hidden", "2016", "a.csv", "b.csv", "notes.txt"}, lines(out))
+
+	out, _ = list(listOptions{}, "data/*.csv")
+	assert.ElementsMatch(t, []string{"data/a.csv", "data/b.csv"}, lines(out))
+
+	out, _ = list(listOptions{all: true}, "data/*.csv")
+	assert.ElementsMatch(t, []string{"data/a.csv", "data/b.csv"}, lines(out))
+
+	out, _ = list(listOptions{byTime: true}, "data/*.csv")
+	assert.Equal(t, "data/b.csv\r\ndata/a.csv\r\n", out)
+
+	out, _ = list(listOptions{long: true}, "data/notes.txt")
+	assert.True(t, strings.HasPrefix(out, "-rw-r--r--"))
+	assert.True(t, strings.HasSuffix(out, " data/notes.txt\r\n"))
+
+	out, _ = list(listOptions{recursive: true}, "data")
+	assert.True(t, strings.HasPrefix(out, "data:\r\n"))
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\ndata/2016:\r\n03\r\n\r\ndata/2016/03:\r\nc.csv\r\n"))
+	assert.ElementsMatch(t, []string{"data:", "2016", "a.csv", "b.csv", "notes.txt", "", "data/2016:", "03", "", "data/2016/03:", "c.csv"}, lines(out))
+
+	ses.SetListLimits(ListLimits{MaxDepth: 1, MaxEntries: 3})
+	out, l := list(listOptions{recursive: true}, "data")
+	assert.Len(t, lines(out), 4)
+	assert.True(t, l.truncated)
+
+	ses.SetListLimits(ListLimits{MaxDepth: 1})
+	out, _ = list(listOptions{recursive: true}, "data")
+	assert.NotContains(t, out, "c.csv")
+
+	assert.Error(t, ses.newListing(listOptions{}).prepare("missing"))
+	assert.Equal(t, "/", ses.fileProvider.CurrentDirectory())
+}
+
+func TestListDefaults(t *testing.T) {
+	ses, conn, dir := newFSTestSession(t)
+	defer os.RemoveAll(dir)
+	replies := collectReplies(conn)
+
+	for _, name := range []string{"notes.txt", ".hidden"} {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), nil, 0644))
+	}
+
+	list := func(tokens ...string) string {
+		dc := newPipeDataChannel()
+		ses.lastDataChanneler = dc
+		ses.dispatch(tokens)
+		assert.True(t, strings.HasPrefix(replies.next(t), "150"))
+		b, err := ioutil.ReadAll(dc.client)
+		assert.NoError(t, err)
+		assert.True(t, strings.HasPrefix(replies.next(t), "226"))
+		<-dc.done
+		return string(b)
+	}
+
+	// without flags LIST and NLST show the hidden files
+	out := list("LIST")
+	assert.Contains(t, out, " .\r\n")
+	assert.Contains(t, out, " ..\r\n")
+	assert.Contains(t, out, " .hidden\r\n")
+
+	out = list("NLST")
+	assert.ElementsMatch(t, []string{".hidden", "notes.txt"}, strings.Fields(out))
+
+	out = list("LIST", "-l")
+	assert.NotContains(t, out, ".hidden")
+	assert.NotContains(t, out, " .\r\n")
+	assert.Contains(t, out, " notes.txt\r\n")
+
+	out = list("NLST", "-a")
+	assert.ElementsMatch(t, []string{".", "..", ".hidden", "notes.txt"}, strings.Fields(out))
+}
+
+func TestListEachStops(t *testing.T) {
+	ses, _, dir := newFSTestSession(t)
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"a", "b", "c"} {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), nil, 0644))
+	}
+
+	// the metrics wrapper forwards the localFS ListStreamer
+	_, ok := ses.fileProvider.(fs.ListStreamer)
+	assert.True(t, ok)
+
+	stop := errors.New("stop")
+	seen := 0
+	err := fs.ListEach(ses.fileProvider, func(f fs.File) error {
+		seen++
+		if seen == 2 {
+			return stop
+		}
+		return nil
+	})
+	assert.Equal(t, stop, err)
+	assert.Equal(t, 2, seen)
+}
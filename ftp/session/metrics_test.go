@@ -0,0 +1,38 @@
+package session
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/mindflavor/ftpserver2/ftp/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandMetrics(t *testing.T) {
+	conn := newFakeConn("10.0.0.1", false)
+	ses := newTestSession(conn)
+	m := metrics.New()
+	ses.SetMetrics(m)
+
+	ses.expectReply("USER")
+	ses.processUSER([]string{"USER", "alice"})
+	ses.expectReply("PASS")
+	ses.processPASS([]string{"PASS", "secret"})
+
+	// not a command reply
+	ses.sendStatement("200 unsolicited")
+
+	buf := new(bytes.Buffer)
+	m.WriteTo(buf)
+	assert.Contains(t, buf.String(), `ftp_commands_total{code="331",command="USER"} 1`)
+	assert.Contains(t, buf.String(), `ftp_commands_total{code="230",command="PASS"} 1`)
+	assert.NotContains(t, buf.String(), `code="200"`)
+	assert.Contains(t, buf.String(), `ftp_logins_total{result="success"} 1`)
+
+	secure, authenticated := ses.State()
+	assert.False(t, secure)
+	assert.False(t, authenticated)
+	ses.publishState()
+	_, authenticated = ses.State()
+	assert.True(t, authenticated)
+}
@@ -0,0 +1,374 @@
+package session
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/mindflavor/ftpserver2/ftp/datachannel"
+	"github.com/mindflavor/ftpserver2/ftp/fs"
+	"github.com/mindflavor/ftpserver2/identity"
+)
+
+// Requirements are checked, in the declaration
+// order, before the CommandFunc is called
+type Requirements int
+
+const (
+	// RequireAuth refuses the command until the user logs in
+	RequireAuth Requirements = 1 << iota
+	// RequireControlTLS applies the TLSPolicy
+	// to the control connection (see SetTLSPolicy)
+	RequireControlTLS
+	// RequireDataTLS applies the TLSPolicy
+	// to the data connection (see SetTLSPolicy)
+	RequireDataTLS
+	// RequireDataChannel refuses the command until the
+	// client issues PASV or EPSV (see Session.DataChannel)
+	RequireDataChannel
+	// KeepREST does not reset the REST offset
+	// (the command consumes it)
+	KeepREST
+	// KeepUSER does not discard the USER of a
+	// login in progress (the command is part of it)
+	KeepUSER
+)
+
+// CommandFunc processes a command. tokens[0] is the
+// verb (the SITE subcommand for SITE extensions).
+// It returns true to close the session.
+type CommandFunc func(ses *Session, tokens []string) bool
+
+// FeatureFunc returns the FEAT lines
+// of a command for the session
+type FeatureFunc func(ses *Session) []string
+
+// Command describes an FTP command (or a SITE
+// subcommand). Permission, if not empty, is checked
+// with the identity.Authorizer of the logged in user.
+type Command struct {
+	Verb         string
+	Func         CommandFunc
+	Requirements Requirements
+	Permission   string
+	Feature      FeatureFunc
+}
+
+// Features returns a FeatureFunc
+// always listing lines
+func Features(lines ...string) FeatureFunc {
+	return func(ses *Session) []string {
+		return lines
+	}
+}
+
+// Registry maps the verbs to the Commands. It's
+// safe to register commands while sessions use it.
+type Registry struct {
+	mutex    sync.RWMutex
+	order    []string
+	commands map[string]*Command
+	site     map[string]*Command
+}
+
+// NewRegistry creates a Registry
+// with the built in commands
+func NewRegistry() *Registry {
+	r := &Registry{
+		commands: make(map[string]*Command),
+		site:     make(map[string]*Command),
+	}
+
+	for _, c := range builtinCommands() {
+		r.Register(c)
+	}
+	for _, c := range builtinSITECommands() {
+		r.RegisterSITE(c)
+	}
+
+	return r
+}
+
+// Register adds c replacing the command
+// with the same verb, if any. FEAT lists the
+// features in the registration order.
+func (r *Registry) Register(c Command) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	c.Verb = strings.ToUpper(c.Verb)
+	if _, ok := r.commands[c.Verb]; !ok {
+		r.order = append(r.order, c.Verb)
+	}
+	r.commands[c.Verb] = &c
+}
+
+// RegisterSITE adds the SITE subcommand c
+// replacing the one with the same verb, if any
+func (r *Registry) RegisterSITE(c Command) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	c.Verb = strings.ToUpper(c.Verb)
+	r.site[c.Verb] = &c
+}
+
+// Unregister removes the command verb
+func (r *Registry) Unregister(verb string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	verb = strings.ToUpper(verb)
+	delete(r.commands, verb)
+	for i, v := range r.order {
+		if v == verb {
+			r.order = append(r.order[:i], r.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// Lookup returns the command verb (nil if not registered)
+func (r *Registry) Lookup(verb string) *Command {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.commands[strings.ToUpper(verb)]
+}
+
+// LookupSITE returns the SITE subcommand
+// verb (nil if not registered)
+func (r *Registry) LookupSITE(verb string) *Command {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.site[strings.ToUpper(verb)]
+}
+
+// siteVerbs returns the sorted SITE subcommands
+func (r *Registry) siteVerbs() []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	var verbs []string
+	for v := range r.site {
+		verbs = append(verbs, v)
+	}
+	sort.Strings(verbs)
+	return verbs
+}
+
+// features returns the FEAT lines for ses
+func (r *Registry) features(ses *Session) []string {
+	r.mutex.RLock()
+	var list []*Command
+	for _, v := range r.order {
+		list = append(list, r.commands[v])
+	}
+	site := make([]*Command, 0, len(r.site))
+	for _, v := range r.site {
+		site = append(site, v)
+	}
+	r.mutex.RUnlock()
+
+	// site is a map, keep FEAT stable
+	sort.Slice(site, func(i, j int) bool {
+		return site[i].Verb < site[j].Verb
+	})
+	list = append(list, site...)
+
+	var lines []string
+	for _, c := range list {
+		if c.Feature != nil {
+			lines = append(lines, c.Feature(ses)...)
+		}
+	}
+	return lines
+}
+
+// execute checks the requirements of c and runs it
+func (c *Command) execute(ses *Session, tokens []string) bool {
+	cmd := newCmdList(ses, tokens, func(tokens []string) bool {
+		return c.Func(ses, tokens)
+	})
+
+	if c.Requirements&RequireAuth != 0 {
+		cmd.requireAuth()
+	}
+	cmd.requirePermission(c.Permission)
+	if c.Requirements&RequireControlTLS != 0 {
+		cmd.requireTLS()
+	}
+	if c.Requirements&RequireDataTLS != 0 {
+		cmd.requirePROT()
+	}
+	if c.Requirements&RequireDataChannel != 0 {
+		cmd.requirePASV()
+	}
+	if c.Requirements&KeepUSER == 0 {
+		cmd.resetUSER()
+	}
+	if c.Requirements&KeepREST == 0 {
+		cmd.resetREST()
+	}
+
+	return cmd.Execute()
+}
+
+// SetRegistry sets the commands
+// understood by the session
+func (ses *Session) SetRegistry(r *Registry) {
+	ses.registry = r
+}
+
+// dispatch runs the command tokens[0]
+func (ses *Session) dispatch(tokens []string) bool {
+	c := ses.registry.Lookup(tokens[0])
+	if c == nil {
+		// do not let arbitrary verbs become metric labels
+		ses.expectReply("UNKNOWN")
+		ses.sendStatement("502 not implemented")
+		return false
+	}
+
+	return c.execute(ses, tokens)
+}
+
+func (ses *Session) processSITE(tokens []string) bool {
+	log.WithFields(log.Fields{"ses": ses, "tokens": tokens, "command": "SITE"}).Info("session::Session::processSITE method begin")
+
+	if len(tokens) < 2 {
+		ses.sendStatement("501 SITE subcommand needed")
+		return false
+	}
+
+	verb := strings.ToUpper(tokens[1])
+	if verb == "HELP" {
+		buf := new(bytes.Buffer)
+		buf.WriteString("214-The following SITE commands are recognized:\r\n")
+		for _, v := range ses.registry.siteVerbs() {
+			buf.WriteString(fmt.Sprintf(" %s\r\n", v))
+		}
+		buf.WriteString("214 Help OK.")
+		ses.sendStatement(buf.String())
+		return false
+	}
+
+	c := ses.registry.LookupSITE(verb)
+	if c == nil {
+		ses.sendStatement(fmt.Sprintf("502 SITE %s not implemented", verb))
+		return false
+	}
+
+	return c.execute(ses, tokens[1:])
+}
+
+// Reply sends a reply on the control connection.
+// Multiline replies must be separated by \r\n.
+func (ses *Session) Reply(statement string) {
+	ses.sendStatement(statement)
+}
+
+// Identity returns the identity
+// of the session user
+func (ses *Session) Identity() identity.Identity {
+	return ses.id
+}
+
+// FileProvider returns the
+// file system of the session
+func (ses *Session) FileProvider() fs.FileProvider {
+	return ses.fileProvider
+}
+
+// DataChannel hands the data connection opened by the
+// last PASV or EPSV over to the caller, which must Close
+// it. It returns nil if there is none: RequireDataChannel
+// refuses the command before.
+func (ses *Session) DataChannel() datachannel.DataChanneler {
+	dc := ses.lastDataChanneler
+	ses.lastDataChanneler = nil // dc in use!
+	return dc
+}
+
+// RemoteAddr returns the address of the client
+func (ses *Session) RemoteAddr() net.Addr {
+	return ses.conn.RemoteAddr()
+}
+
+// builtinCommands returns the commands
+// implemented by the session
+func builtinCommands() []Command {
+	return []Command{
+		{Verb: commands[USER], Func: (*Session).processUSER, Requirements: RequireControlTLS | KeepUSER, Feature: Features(commands[USER])},
+		{Verb: commands[PASS], Func: (*Session).processPASS, Requirements: RequireControlTLS | KeepUSER, Feature: Features(commands[PASS])},
+		{Verb: commands[PWD], Func: (*Session).processPWD, Requirements: RequireAuth, Feature: Features(commands[PWD])},
+		{Verb: commands[TYPE], Func: (*Session).processTYPE, Requirements: RequireAuth, Feature: Features(commands[TYPE])},
+		{Verb: commands[PASV], Func: (*Session).processPASV, Requirements: RequireAuth, Feature: Features(commands[PASV])},
+		{Verb: commands[EPSV], Func: (*Session).processEPSV, Requirements: RequireAuth, Feature: Features(commands[EPSV])},
+		{Verb: commands[LIST], Func: (*Session).processLIST, Requirements: RequireAuth | RequireDataTLS | RequireDataChannel, Permission: identity.PermissionList, Feature: Features(commands[LIST])},
+		{Verb: commands[SYST], Func: (*Session).processSYST, Feature: Features(commands[SYST])},
+		{Verb: commands[CWD], Func: (*Session).processCWD, Requirements: RequireAuth, Feature: Features(commands[CWD])},
+		{Verb: commands[CDUP], Func: (*Session).processCDUP, Requirements: RequireAuth, Feature: Features(commands[CDUP])},
+		{Verb: commands[SIZE], Func: (*Session).processSIZE, Requirements: RequireAuth, Permission: identity.PermissionList, Feature: Features(commands[SIZE])},
+		{Verb: commands[RETR], Func: (*Session).processRETR, Requirements: RequireAuth | RequireDataTLS | RequireDataChannel | KeepREST, Permission: identity.PermissionRead, Feature: Features(commands[RETR])},
+		{Verb: commands[STOR], Func: (*Session).processSTOR, Requirements: RequireAuth | RequireDataTLS | RequireDataChannel, Permission: identity.PermissionWrite, Feature: Features(commands[STOR])},
+		{Verb: commands[DELE], Func: (*Session).processDELE, Requirements: RequireAuth, Permission: identity.PermissionDelete, Feature: Features(commands[DELE])},
+		{Verb: commands[FEAT], Func: (*Session).processFEAT, Feature: Features(commands[FEAT])},
+		{Verb: commands[QUIT], Func: (*Session).processQUIT, Feature: Features(commands[QUIT])},
+		{Verb: commands[NOOP], Func: (*Session).processNOOP, Feature: Features(commands[NOOP])},
+		{Verb: commands[MKD], Func: (*Session).processMKD, Requirements: RequireAuth, Permission: identity.PermissionMkdir, Feature: Features(commands[MKD])},
+		{Verb: commands[RMD], Func: (*Session).processRMD, Requirements: RequireAuth, Permission: identity.PermissionDelete, Feature: Features(commands[RMD])},
+		{Verb: commands[REST], Func: (*Session).processREST, Requirements: RequireAuth | RequireDataChannel, Feature: Features(commands[REST])},
+		{Verb: commands[NLST], Func: (*Session).processNLST, Requirements: RequireAuth | RequireDataTLS | RequireDataChannel, Permission: identity.PermissionList, Feature: Features(commands[NLST])},
+		{Verb: commands[XCRC], Func: (*Session).processXCRC, Requirements: RequireAuth, Permission: identity.PermissionRead, Feature: Features(commands[XCRC])},
+		{Verb: commands[XMD5], Func: (*Session).processXMD5, Requirements: RequireAuth, Permission: identity.PermissionRead, Feature: Features(commands[XMD5])},
+		{Verb: commands[XSHA1], Func: (*Session).processXSHA1, Requirements: RequireAuth, Permission: identity.PermissionRead, Feature: Features(commands[XSHA1])},
+		{Verb: commands[XSHA256], Func: (*Session).processXSHA256, Requirements: RequireAuth, Permission: identity.PermissionRead, Feature: Features(commands[XSHA256])},
+		{Verb: "AUTH", Func: (*Session).processAUTH, Feature: func(ses *Session) []string {
+			if ses.tlsConfig != nil && !ses.conn.IsSecure() {
+				return []string{"AUTH TLS"}
+			}
+			return nil
+		}},
+		{Verb: "PBSZ", Func: (*Session).processPBSZ, Feature: func(ses *Session) []string {
+			if ses.tlsConfig != nil {
+				return []string{"PBSZ"}
+			}
+			return nil
+		}},
+		{Verb: "PROT", Func: (*Session).processPROT, Feature: func(ses *Session) []string {
+			if ses.tlsConfig != nil {
+				return []string{"PROT"}
+			}
+			return nil
+		}},
+		{Verb: "CCC", Func: (*Session).processCCC, Requirements: RequireAuth, Feature: func(ses *Session) []string {
+			if ses.cccAllowed() {
+				return []string{"CCC"}
+			}
+			return nil
+		}},
+		{Verb: "RMDA", Func: (*Session).processRMDA, Requirements: RequireAuth, Permission: identity.PermissionDelete, Feature: func(ses *Session) []string {
+			if ses.recursiveRemover() != nil {
+				return []string{"RMDA"}
+			}
+			return nil
+		}},
+		{Verb: "HASH", Func: (*Session).processHASH, Requirements: RequireAuth, Permission: identity.PermissionRead, Feature: func(ses *Session) []string {
+			return []string{ses.hashFeature()}
+		}},
+		{Verb: "MODE", Func: (*Session).processMODE, Requirements: RequireAuth, Feature: Features("MODE Z")},
+		{Verb: "OPTS", Func: (*Session).processOPTS},
+		{Verb: "RNFR", Func: (*Session).processRNFR, Requirements: RequireAuth, Permission: identity.PermissionRename},
+		{Verb: "RNTO", Func: (*Session).processRNTO, Requirements: RequireAuth, Permission: identity.PermissionRename},
+		{Verb: "SITE", Func: (*Session).processSITE, Requirements: RequireAuth},
+		{Verb: "STAT", Func: (*Session).processSTAT, Requirements: RequireAuth | KeepREST},
+		{Verb: "MLSD", Func: (*Session).processMLSD, Requirements: RequireAuth | RequireDataTLS | RequireDataChannel, Permission: identity.PermissionList},
+		{Verb: "MLST", Func: (*Session).processMLST, Requirements: RequireAuth, Permission: identity.PermissionList, Feature: Features("MLST type*;size*;modify*;perm*;UNIX.mode*;UNIX.owner*;UNIX.group*;")},
+	}
+}
@@ -0,0 +1,141 @@
+package session
+
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/mindflavor/ftpserver2/identity"
+	"github.com/mindflavor/ftpserver2/identity/basic"
+	"github.com/stretchr/testify/assert"
+)
+
+// readOnly is an identity.Authorizer
+// allowing list and read only
+type readOnly struct {
+	identity.Identity
+}
+
+func (readOnly) HasPermission(permission string) bool {
+	return permission == identity.PermissionList || permission == identity.PermissionRead
+}
+
+func TestRegistryFEAT(t *testing.T) {
+	conn := newFakeConn("10.0.0.1", false)
+	ses := newTestSession(conn)
+
+	ses.dispatch([]string{"FEAT"})
+	out := conn.out.String()
+	assert.True(t, strings.HasPrefix(out, "211-Features:\r\n USER\r\n PASS\r\n"))
+	assert.Contains(t, out, " XSHA256\r\n HASH ")
+	assert.Contains(t, out, " MODE Z\r\n MLST type*;size*;modify*;perm*;UNIX.mode*;UNIX.owner*;UNIX.group*;\r\n211 End")
+	assert.NotContains(t, out, "AUTH TLS")
+	assert.NotContains(t, out, "OPTS")
+
+	ses.registry.RegisterSITE(Command{Verb: "stats", Feature: Features("SITE STATS")})
+	ses.registry.Unregister("XCRC")
+	conn.out.Reset()
+	ses.dispatch([]string{"FEAT"})
+	assert.Contains(t, conn.out.String(), "UNIX.group*;\r\n SITE STATS\r\n211 End")
+	assert.NotContains(t, conn.out.String(), "XCRC")
+}
+
+func TestRegistryCustomCommands(t *testing.T) {
+	conn := newFakeConn("10.0.0.1", false)
+	ses := newTestSession(conn)
+
+	ses.registry.Register(Command{
+		Verb:         "WHOAMI",
+		Requirements: RequireAuth,
+		Func: func(ses *Session, tokens []string) bool {
+			ses.Reply("200 " + ses.Identity().Username())
+			return false
+		},
+	})
+	ses.registry.RegisterSITE(Command{
+		Verb:         "ECHO",
+		Requirements: RequireAuth,
+		Func: func(ses *Session, tokens []string) bool {
+			ses.Reply("200 " + strings.Join(tokens[1:], " "))
+			return false
+		},
+	})
+
+	ses.dispatch([]string{"WHOAMI"})
+	assert.True(t, strings.HasPrefix(conn.lastReply(), "530"))
+
+	ses.id = basicidentity.New("alice", true)
+
+	ses.dispatch([]string{"WHOAMI"})
+	assert.Equal(t, "200 alice", conn.lastReply())
+
+	ses.dispatch([]string{"SITE", "echo", "hello", "world"})
+	assert.Equal(t, "200 hello world", conn.lastReply())
+
+	ses.dispatch([]string{"SITE", "BOGUS", "file"})
+	assert.Equal(t, "502 SITE BOGUS not implemented", conn.lastReply())
+
+	ses.dispatch([]string{"SITE", "HELP"})
+	assert.Contains(t, conn.out.String(), "214-The following SITE commands are recognized:\r\n CHMOD\r\n CHOWN\r\n ECHO\r\n UTIME\r\n214 Help OK.")
+
+	ses.dispatch([]string{"BOGUS"})
+	assert.Equal(t, "502 not implemented", conn.lastReply())
+}
+
+func TestRegistryDataChannelCommand(t *testing.T) {
+	conn := newFakeConn("10.0.0.1", false)
+	ses := newTestSession(conn)
+	ses.id = basicidentity.New("alice", true)
+	replies := collectReplies(conn)
+
+	ses.registry.Register(Command{
+		Verb:         "XMOTD",
+		Requirements: RequireAuth | RequireDataChannel,
+		Func: func(ses *Session, tokens []string) bool {
+			dc := ses.DataChannel()
+			dc.Sink(func(w io.Writer, r io.Reader) error {
+				defer dc.Close()
+
+				ses.Reply("150 Sending the message of the day.")
+				if _, err := io.WriteString(w, "welcome\r\n"); err != nil {
+					return err
+				}
+				ses.Reply("226 Transfer complete.")
+				return nil
+			})
+			return false
+		},
+	})
+
+	ses.dispatch([]string{"XMOTD"})
+	assert.True(t, strings.HasPrefix(replies.next(t), "425"))
+
+	dc := newPipeDataChannel()
+	ses.lastDataChanneler = dc
+	ses.dispatch([]string{"XMOTD"})
+	assert.Nil(t, ses.lastDataChanneler)
+
+	assert.Equal(t, "150 Sending the message of the day.", replies.next(t))
+	b, err := ioutil.ReadAll(dc.client)
+	assert.NoError(t, err)
+	assert.Equal(t, "welcome\r\n", string(b))
+	assert.Equal(t, "226 Transfer complete.", replies.next(t))
+	<-dc.done
+}
+
+func TestRegistryPermissions(t *testing.T) {
+	conn := newFakeConn("10.0.0.1", false)
+	ses := newTestSession(conn)
+	ses.id = readOnly{basicidentity.New("guest", true)}
+
+	ses.dispatch([]string{"MKD", "dir"})
+	assert.Equal(t, "550 Permission denied.", conn.lastReply())
+
+	ses.dispatch([]string{"STOR", "file"})
+	assert.Equal(t, "550 Permission denied.", conn.lastReply())
+
+	// allowed, refused later by the missing PASV
+	ses.dispatch([]string{"RETR", "file"})
+	assert.True(t, strings.HasPrefix(conn.lastReply(), "425"))
+}
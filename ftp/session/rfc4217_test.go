@@ -0,0 +1,58 @@
+package session
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPBSZBeforePROT(t *testing.T) {
+	conn := newFakeConn("10.0.0.1", false)
+	ses := newTestSession(conn)
+
+	ses.processPBSZ([]string{"PBSZ", "0"})
+	assert.True(t, strings.HasPrefix(conn.lastReply(), "503"))
+
+	conn.SwitchToTLS()
+	ses.processPROT([]string{"PROT", "P"})
+	assert.True(t, strings.HasPrefix(conn.lastReply(), "503"))
+
+	ses.processPBSZ([]string{"PBSZ", "0"})
+	assert.Equal(t, "200 PBSZ=0", conn.lastReply())
+
+	ses.processPROT([]string{"PROT", "S"})
+	assert.True(t, strings.HasPrefix(conn.lastReply(), "536"))
+
+	ses.processPROT([]string{"PROT", "P"})
+	assert.True(t, strings.HasPrefix(conn.lastReply(), "200"))
+	assert.True(t, ses.dataChannelEncryption)
+}
+
+func TestCCC(t *testing.T) {
+	// explicit TLS
+	conn := newFakeConn("10.0.0.1", false)
+	ses := newTestSession(conn)
+	conn.SwitchToTLS()
+
+	ses.processCCC([]string{"CCC"})
+	assert.True(t, strings.HasPrefix(conn.lastReply(), "200"))
+	assert.False(t, conn.IsSecure())
+
+	ses.processCCC([]string{"CCC"})
+	assert.True(t, strings.HasPrefix(conn.lastReply(), "533"))
+
+	// implicit TLS
+	conn = newFakeConn("10.0.0.1", true)
+	ses = newTestSession(conn)
+	ses.processCCC([]string{"CCC"})
+	assert.True(t, strings.HasPrefix(conn.lastReply(), "534"))
+
+	// policy
+	conn = newFakeConn("10.0.0.1", false)
+	ses = newTestSession(conn)
+	ses.SetTLSPolicy(TLSPolicy{RequireControl: true})
+	conn.SwitchToTLS()
+	ses.processCCC([]string{"CCC"})
+	assert.True(t, strings.HasPrefix(conn.lastReply(), "534"))
+}
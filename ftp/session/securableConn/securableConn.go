@@ -7,8 +7,11 @@ package securableConn
 import (
 	"bufio"
 	"crypto/tls"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"net"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -20,16 +23,18 @@ import (
 type Conn interface {
 	io.Closer
 	SwitchToTLS() error
+	SwitchToPlain() error
 	LocalAddr() net.Addr
 	RemoteAddr() net.Addr
 	Writer() *bufio.Writer
 	Reader() *bufio.Reader
 	IsSecure() bool
+	ConnectionState() *tls.ConnectionState
 }
 
 type conn struct {
 	secure *tls.Conn
-	cert   *tls.Certificate
+	config *tls.Config
 	plain  net.Conn
 
 	bufr *bufio.Reader
@@ -37,12 +42,13 @@ type conn struct {
 }
 
 // New creates a new securableConn.Conn. It can already have
-// a secure channel open, in which case it will be used
-func New(plain net.Conn, secure *tls.Conn, cert *tls.Certificate) Conn {
+// a secure channel open, in which case it will be used.
+// config is used by SwitchToTLS.
+func New(plain net.Conn, secure *tls.Conn, config *tls.Config) Conn {
 	c := &conn{
 		plain:  plain,
 		secure: secure,
-		cert:   cert,
+		config: config,
 	}
 
 	if secure != nil {
@@ -61,26 +67,67 @@ func New(plain net.Conn, secure *tls.Conn, cert *tls.Certificate) Conn {
 func (c *conn) SwitchToTLS() error {
 	log.WithFields(log.Fields{"c": c}).Debug("securableConn::conn::SwitchToTLS called")
 
-	sslConfig := tls.Config{Certificates: []tls.Certificate{*c.cert}}
-
-	log.WithFields(log.Fields{"c": c, "sslConfig": sslConfig}).Debug("securableConn::conn::SwitchToTLS sslConfig created")
+	if c.config == nil {
+		return fmt.Errorf("no TLS configuration available")
+	}
 
-	srv := tls.Server(c.plain, &sslConfig)
-	log.WithFields(log.Fields{"c": c, "sslConfig": sslConfig, "srv": srv}).Debug("securableConn::conn::SwitchToTLS tls.Server created")
+	srv := tls.Server(c.plain, c.config)
+	log.WithFields(log.Fields{"c": c, "srv": srv}).Debug("securableConn::conn::SwitchToTLS tls.Server created")
 
 	//	err := srv.Handshake()
 	//	if err != nil {
 	//		return err
 	//	}
 
-	log.WithFields(log.Fields{"c": c, "sslConfig": sslConfig}).Debug("securableConn::conn::SwitchToTLS done")
+	log.WithFields(log.Fields{"c": c}).Debug("securableConn::conn::SwitchToTLS done")
 
 	c.secure = srv
 
 	c.bufr = bufio.NewReader(c.secure)
 	c.bufw = bufio.NewWriter(c.secure)
 
-	log.WithFields(log.Fields{"c": c, "sslConfig": sslConfig}).Debug("securableConn::conn::SwitchToTLS ending")
+	log.WithFields(log.Fields{"c": c}).Debug("securableConn::conn::SwitchToTLS ending")
+	return nil
+}
+
+// SwitchToPlain shuts down the TLS layer of an
+// explicit (AUTH TLS) connection keeping the underlying
+// connection open (see the CCC command). The peer must
+// answer with its own close_notify alert.
+func (c *conn) SwitchToPlain() error {
+	log.WithFields(log.Fields{"c": c}).Debug("securableConn::conn::SwitchToPlain called")
+
+	if c.secure == nil {
+		return nil
+	}
+
+	if c.plain == nil {
+		return fmt.Errorf("implicit TLS connections cannot be downgraded")
+	}
+
+	if err := c.bufw.Flush(); err != nil {
+		return err
+	}
+
+	// send our close_notify...
+	if err := c.secure.CloseWrite(); err != nil {
+		return err
+	}
+
+	// ...and wait for the peer one (Read returns io.EOF)
+	c.plain.SetReadDeadline(time.Now().Add(10 * time.Second))
+	defer c.plain.SetReadDeadline(time.Time{})
+
+	if _, err := io.Copy(ioutil.Discard, c.bufr); err != nil {
+		return err
+	}
+
+	c.secure = nil
+
+	c.bufr = bufio.NewReader(c.plain)
+	c.bufw = bufio.NewWriter(c.plain)
+
+	log.WithFields(log.Fields{"c": c}).Debug("securableConn::conn::SwitchToPlain ending")
 	return nil
 }
 
@@ -110,6 +157,17 @@ func (c *conn) IsSecure() bool {
 	return c.secure != nil
 }
 
+// ConnectionState returns the TLS state
+// of the connection (nil if not encrypted)
+func (c *conn) ConnectionState() *tls.ConnectionState {
+	if c.secure == nil {
+		return nil
+	}
+
+	cs := c.secure.ConnectionState()
+	return &cs
+}
+
 func (c *conn) Close() error {
 	if c.secure != nil {
 		err := c.secure.Close()
@@ -3,18 +3,25 @@
 package session
 
 import (
+	"compress/zlib"
 	"crypto/tls"
 	"fmt"
 	"io"
 	"net"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	log "github.com/sirupsen/logrus"
+	"github.com/mindflavor/ftpserver2/ftp/auditlog"
 	"github.com/mindflavor/ftpserver2/ftp/datachannel"
 	"github.com/mindflavor/ftpserver2/ftp/fs"
+	"github.com/mindflavor/ftpserver2/ftp/logintracker"
+	"github.com/mindflavor/ftpserver2/ftp/metrics"
 	"github.com/mindflavor/ftpserver2/ftp/portassigner"
 	"github.com/mindflavor/ftpserver2/ftp/session/securableConn"
+	"github.com/mindflavor/ftpserver2/ftp/throttle"
 	"github.com/mindflavor/ftpserver2/identity"
 	"github.com/mindflavor/ftpserver2/identity/basic"
 )
@@ -42,9 +49,10 @@ const (
 	RMD
 	REST
 	NLST
-
-//	AUTH auth must be handled manually
-//	PROT auth must be handled manually
+	XCRC
+	XMD5
+	XSHA1
+	XSHA256
 )
 
 var commands = []string{
@@ -69,14 +77,18 @@ var commands = []string{
 	"RMD",
 	"REST",
 	"NLST",
-	//	"AUTH", auth must be handled manually
-	//	"PROT", auth must be handled manually
+	"XCRC",
+	"XMD5",
+	"XSHA1",
+	"XSHA256",
+	// the other verbs are registered
+	// directly in builtinCommands
 }
 
 // Session is the connected FTP session
 type Session struct {
 	conn                  securableConn.Conn
-	cert                  *tls.Certificate
+	tlsConfig             *tls.Config
 	lastReceivedCommand   time.Time
 	id                    identity.Identity
 	pa                    portassigner.PortAssigner
@@ -86,13 +98,81 @@ type Session struct {
 	connectionTimeout     time.Duration
 	dataChannelEncryption bool
 	lastREST              int64
+	allowRecursiveDelete  bool
+	hashAlgorithm         string
+	transferType          string
+	transferMode          string
+	deflateLevel          int
+	identityAuthFunc      IdentityAuthenticatorFunc
+	downloadLimiter       *throttle.Limiter
+	uploadLimiter         *throttle.Limiter
+	globalDownloadLimiter *throttle.Limiter
+	globalUploadLimiter   *throttle.Limiter
+	identityLimits        bool
+	limitsMutex           sync.Mutex
+	loginCounter          LoginCounter
+	loggedUser            string
+	loginTracker          *logintracker.Tracker
+	failedLogins          int
+	ipRules               IPRules
+	tlsPolicy             TLSPolicy
+	requireTLSResumption  bool
+	pbszSet               bool
+	implicitTLS           bool
+	metrics               *metrics.Metrics
+	auditLog              *auditlog.Logger
+	hooks                 *Hooks
+	registry              *Registry
+	renameFrom            string
+	maskOwner             bool
+	listLimits            ListLimits
+	transferMutex         sync.Mutex
+	transfer              *transfer
+	replyMutex            sync.Mutex
+	pendingVerb           string
+	secureState           int32
+	authenticatedState    int32
+	clientCertMode        ClientCertMode
+	certAuthFunc          CertificateAuthenticatorFunc
+}
+
+// LoginCounter tracks the concurrent logins of
+// the users. Acquire is called after a successful PASS
+// and must return false if the user is over its limit.
+// Release is called when the user logs out.
+type LoginCounter interface {
+	Acquire(username string) bool
+	Release(username string)
 }
 
-// New creates a new FTP session
-func New(conn securableConn.Conn, cert *tls.Certificate, connectionTimeout time.Duration, portassigner portassigner.PortAssigner, authFunc AuthenticatorFunc, fp fs.FileProvider) *Session {
+// IPRules decides if a user is allowed
+// to log in from a remote IP. It's checked
+// after a successful PASS.
+type IPRules interface {
+	UserAllowed(username string, ip net.IP) bool
+}
+
+// Transfer types (see TYPE command)
+const (
+	typeASCII  = "A"
+	typeBinary = "I"
+)
+
+// Transfer modes (see MODE command)
+const (
+	modeStream  = "S"
+	modeDeflate = "Z"
+)
+
+// New creates a new FTP session. tlsConfig is used by
+// AUTH TLS and by the encrypted data connections (nil
+// disables them): it should be the result of BindTLSConfig
+// so the data connections can resume the control session.
+func New(conn securableConn.Conn, tlsConfig *tls.Config, connectionTimeout time.Duration, portassigner portassigner.PortAssigner, authFunc AuthenticatorFunc, fp fs.FileProvider) *Session {
 	return &Session{
 		conn:                  conn,
-		cert:                  cert,
+		tlsConfig:             tlsConfig,
+		implicitTLS:           conn.IsSecure(),
 		connectionTimeout:     connectionTimeout,
 		lastReceivedCommand:   time.Now(),
 		pa:                    portassigner,
@@ -101,7 +181,129 @@ func New(conn securableConn.Conn, cert *tls.Certificate, connectionTimeout time.
 		id:                    basicidentity.New("", false),
 		lastREST:              0,
 		dataChannelEncryption: false,
+		hashAlgorithm:         fs.HashSHA256,
+		transferType:          typeBinary,
+		transferMode:          modeStream,
+		deflateLevel:          zlib.DefaultCompression,
+		downloadLimiter:       throttle.NewLimiter(0),
+		uploadLimiter:         throttle.NewLimiter(0),
+		registry:              NewRegistry(),
+		listLimits:            DefaultListLimits(),
+	}
+}
+
+// SetAllowRecursiveDelete enables the RMDA command
+// (remove a directory and all its content). It's effective
+// only if the FileProvider implements fs.RecursiveRemover.
+func (ses *Session) SetAllowRecursiveDelete(allow bool) {
+	ses.allowRecursiveDelete = allow
+}
+
+// SetIdentityAuthenticator makes the session use an
+// IdentityAuthenticatorFunc instead of the AuthenticatorFunc
+// passed to New
+func (ses *Session) SetIdentityAuthenticator(f IdentityAuthenticatorFunc) {
+	ses.identityAuthFunc = f
+}
+
+// SetGlobalBandwidthLimiters sets the limiters shared
+// between all the sessions. Either can be nil.
+func (ses *Session) SetGlobalBandwidthLimiters(download, upload *throttle.Limiter) {
+	ses.globalDownloadLimiter = download
+	ses.globalUploadLimiter = upload
+}
+
+// SetBandwidthLimits sets the session transfer rate limits
+// in bytes per second (0 means unlimited). The limits
+// of an identity implementing identity.BandwidthLimited
+// take precedence so, after such an user logs in,
+// this call is ignored.
+func (ses *Session) SetBandwidthLimits(download, upload int64) {
+	ses.limitsMutex.Lock()
+	defer ses.limitsMutex.Unlock()
+
+	if ses.identityLimits {
+		return
+	}
+
+	ses.downloadLimiter.SetRate(download)
+	ses.uploadLimiter.SetRate(upload)
+}
+
+// SetLoginCounter sets the LoginCounter used to limit
+// the concurrent logins per user
+func (ses *Session) SetLoginCounter(lc LoginCounter) {
+	ses.loginCounter = lc
+}
+
+// SetLoginTracker sets the tracker used to delay,
+// limit and ban failed logins. It can be nil.
+func (ses *Session) SetLoginTracker(t *logintracker.Tracker) {
+	ses.loginTracker = t
+}
+
+// SetIPRules sets the per user IP rules.
+// It can be nil.
+func (ses *Session) SetIPRules(r IPRules) {
+	ses.ipRules = r
+}
+
+// allowedFrom returns false if the user is not
+// allowed to log in from the client IP, either because
+// of the IPRules or the identity.IPRestricted identity
+func (ses *Session) allowedFrom(id identity.Identity) bool {
+	ip := net.ParseIP(ses.remoteIP())
+
+	if ses.ipRules != nil && !ses.ipRules.UserAllowed(id.Username(), ip) {
+		return false
 	}
+
+	if r, ok := id.(identity.IPRestricted); ok && !r.AllowedFrom(ip) {
+		return false
+	}
+
+	return true
+}
+
+// SetTLSPolicy sets the policy forcing
+// the encryption of the control and data connections
+func (ses *Session) SetTLSPolicy(p TLSPolicy) {
+	ses.tlsPolicy = p
+}
+
+// SetRequireTLSResumption makes the encrypted data
+// connections that do not resume the TLS session of the
+// control connection fail with 522. It protects against
+// data connection theft but some clients do not support it.
+func (ses *Session) SetRequireTLSResumption(require bool) {
+	ses.requireTLSResumption = require
+}
+
+// SetMetrics sets the metrics updated
+// by the session. It can be nil.
+func (ses *Session) SetMetrics(m *metrics.Metrics) {
+	ses.metrics = m
+}
+
+// State returns whether the control connection is
+// encrypted and the user is logged in. It's safe to call
+// it from other go funcs (the state is updated after every
+// command).
+func (ses *Session) State() (secure, authenticated bool) {
+	return atomic.LoadInt32(&ses.secureState) == 1, atomic.LoadInt32(&ses.authenticatedState) == 1
+}
+
+// publishState updates the values returned by State
+func (ses *Session) publishState() {
+	var secure, authenticated int32
+	if ses.conn.IsSecure() {
+		secure = 1
+	}
+	if ses.id.Authenticated() {
+		authenticated = 1
+	}
+	atomic.StoreInt32(&ses.secureState, secure)
+	atomic.StoreInt32(&ses.authenticatedState, authenticated)
 }
 
 func (ses *Session) String() string {
@@ -120,6 +322,7 @@ func (ses *Session) Handle() error {
 	}).Debug("session::Session::Handle started")
 
 	ses.sendStatement("200 GOlang FTP Server welcomes you!")
+	ses.publishState()
 	terminateProcessing := false
 
 	for !terminateProcessing {
@@ -140,61 +343,20 @@ func (ses *Session) Handle() error {
 		if len(tokens) < 1 { // nothing to handle
 			continue
 		}
+		tokens[0] = strings.ToUpper(tokens[0])
 
 		ses.lastReceivedCommand = time.Now()
+		ses.expectReply(tokens[0])
 
-		switch tokens[0] {
-		case commands[USER]:
-			terminateProcessing = newCmdList(ses, tokens, ses.processUSER).resetREST().Execute()
-		case commands[PASS]:
-			terminateProcessing = newCmdList(ses, tokens, ses.processPASS).resetREST().Execute()
-		case commands[PWD]:
-			terminateProcessing = newCmdList(ses, tokens, ses.processPWD).requireAuth().resetUSER().resetREST().Execute()
-		case commands[TYPE]:
-			terminateProcessing = newCmdList(ses, tokens, ses.processTYPE).requireAuth().resetUSER().resetREST().Execute()
-		case commands[PASV]:
-			terminateProcessing = newCmdList(ses, tokens, ses.processPASV).requireAuth().resetUSER().resetREST().Execute()
-		case commands[EPSV]:
-			terminateProcessing = newCmdList(ses, tokens, ses.processEPSV).requireAuth().resetUSER().resetREST().Execute()
-		case commands[LIST]:
-			terminateProcessing = newCmdList(ses, tokens, ses.processLIST).requireAuth().requirePASV().resetUSER().resetREST().Execute()
-		case commands[SYST]:
-			terminateProcessing = newCmdList(ses, tokens, ses.processSYST).resetUSER().resetREST().Execute()
-		case commands[CWD]:
-			terminateProcessing = newCmdList(ses, tokens, ses.processCWD).requireAuth().resetUSER().resetREST().Execute()
-		case commands[CDUP]:
-			terminateProcessing = newCmdList(ses, tokens, ses.processCDUP).requireAuth().resetUSER().resetREST().Execute()
-		case commands[SIZE]:
-			terminateProcessing = newCmdList(ses, tokens, ses.processSIZE).requireAuth().resetUSER().resetREST().Execute()
-		case commands[RETR]:
-			terminateProcessing = newCmdList(ses, tokens, ses.processRETR).requireAuth().resetUSER().requirePASV().Execute()
-		case commands[STOR]:
-			terminateProcessing = newCmdList(ses, tokens, ses.processSTOR).requireAuth().resetUSER().resetREST().requirePASV().Execute()
-		case commands[FEAT]:
-			terminateProcessing = newCmdList(ses, tokens, ses.processFEAT).requireAuth().resetUSER().resetREST().Execute()
-		case commands[QUIT]:
-			terminateProcessing = newCmdList(ses, tokens, ses.processQUIT).resetUSER().resetREST().Execute()
-		case commands[NOOP]:
-			terminateProcessing = newCmdList(ses, tokens, ses.processNOOP).resetUSER().resetREST().Execute()
-		case commands[MKD]:
-			terminateProcessing = newCmdList(ses, tokens, ses.processMKD).requireAuth().resetUSER().resetREST().Execute()
-		case commands[RMD]:
-			terminateProcessing = newCmdList(ses, tokens, ses.processRMD).requireAuth().resetUSER().resetREST().Execute()
-		case commands[DELE]:
-			terminateProcessing = newCmdList(ses, tokens, ses.processDELE).requireAuth().resetUSER().resetREST().Execute()
-		case commands[REST]:
-			terminateProcessing = newCmdList(ses, tokens, ses.processREST).requireAuth().requirePASV().resetUSER().resetREST().Execute()
-		case commands[NLST]:
-			terminateProcessing = newCmdList(ses, tokens, ses.processNLST).requireAuth().requirePASV().resetUSER().resetREST().Execute()
-		case "AUTH":
-			terminateProcessing = newCmdList(ses, tokens, ses.processAUTH).resetUSER().resetREST().Execute()
-		case "PROT":
-			terminateProcessing = newCmdList(ses, tokens, ses.processPROT).requireAuth().resetUSER().resetREST().Execute()
-		default:
-			ses.sendStatement("502 not implemented")
+		// RNTO must immediately follow RNFR
+		if tokens[0] != "RNTO" {
+			ses.renameFrom = ""
 		}
 
+		terminateProcessing = ses.dispatch(tokens)
+
 		log.WithFields(log.Fields{"Session": ses, "terminateProcessing": terminateProcessing}).Debug("session::Session::Handle message processing completed")
+		ses.publishState()
 	}
 
 	return nil
@@ -202,6 +364,14 @@ func (ses *Session) Handle() error {
 
 // Close closes the connection
 func (ses *Session) Close() {
+	if ses.id.Authenticated() {
+		e := ses.newEvent(EventLogout, "")
+		ses.beforeEvent(e)
+		ses.afterEvent(e)
+	}
+
+	ses.releaseLogin()
+
 	// close the control connection
 	ses.conn.Close()
 
@@ -211,6 +381,15 @@ func (ses *Session) Close() {
 	}
 }
 
+// releaseLogin releases the slot acquired
+// by the logged in user, if any
+func (ses *Session) releaseLogin() {
+	if ses.loggedUser != "" && ses.loginCounter != nil {
+		ses.loginCounter.Release(ses.loggedUser)
+	}
+	ses.loggedUser = ""
+}
+
 func (ses *Session) sendStatement(statement string) {
 	if statement[len(statement)-2:] != "\r\n" {
 		statement += "\r\n"
@@ -218,6 +397,16 @@ func (ses *Session) sendStatement(statement string) {
 
 	log.WithField("statement", statement[:len(statement)-2]).Debug("session::Session::sendStatement sending statement")
 
+	// the transfer replies are sent by the data connection go func
+	ses.replyMutex.Lock()
+	defer ses.replyMutex.Unlock()
+
+	// a 1xx reply is followed by the final one
+	if ses.pendingVerb != "" && len(statement) >= 3 && statement[0] != '1' {
+		ses.metrics.Command(ses.pendingVerb, statement[:3])
+		ses.pendingVerb = ""
+	}
+
 	_, err := ses.conn.Writer().WriteString(statement)
 	if err != nil {
 		log.WithFields(log.Fields{"statement": statement[:len(statement)-2], "err": err}).Warn("session::Session::sendStatement error sending statement")
@@ -228,6 +417,14 @@ func (ses *Session) sendStatement(statement string) {
 	}
 }
 
+// expectReply marks verb as the command the
+// next reply code is counted for (see metrics)
+func (ses *Session) expectReply(verb string) {
+	ses.replyMutex.Lock()
+	defer ses.replyMutex.Unlock()
+	ses.pendingVerb = verb
+}
+
 func (ses *Session) readCommand() (string, error) {
 	buf, err := ses.conn.Reader().ReadString('\n')
 	if err != nil {
@@ -244,6 +441,17 @@ func (ses *Session) readCommand() (string, error) {
 	return cmd, nil
 }
 
+// remoteIP returns the IP address
+// of the client (without the port)
+func (ses *Session) remoteIP() string {
+	addr := ses.conn.RemoteAddr().String()
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
 func getLocalIP() (net.IP, error) {
 	ifaces, err := net.Interfaces()
 	if err != nil {
@@ -293,15 +501,81 @@ func (ses *Session) retrievePassivePort() error {
 
 	// Initialize and store the connection
 	var err error
-	ses.lastDataChanneler, err = datachannel.New(ses.pa, ses.cert, ses.dataChannelEncryption)
+	ses.lastDataChanneler, err = datachannel.New(ses.pa, ses.tlsConfig, ses.dataChannelEncryption, ses.requireTLSResumption)
 
 	if err != nil {
 		return err
 	}
 
+	ses.lastDataChanneler.OnRefused(func(err error) {
+		if err == datachannel.ErrNotResumed {
+			ses.sendStatement("522 Data connections must resume the TLS session of the control connection.")
+			return
+		}
+		ses.sendStatement(fmt.Sprintf("522 Data connection TLS negotiation failed: %s.", err))
+	})
+
 	return nil
 }
 
+// countTransfer records the transfer on dc in the
+// direction metrics (metrics.Upload or metrics.Download)
+func (ses *Session) countTransfer(dc datachannel.DataChanneler, direction string) {
+	dc.OnTransfer(func(read, written int64, elapsed time.Duration) {
+		if direction == metrics.Upload {
+			ses.metrics.Transfer(direction, read, elapsed)
+		} else {
+			ses.metrics.Transfer(direction, written, elapsed)
+		}
+	})
+}
+
+// transferSettings are the TYPE and MODE of a transfer.
+// They are read by the command, before the data connection
+// go routine starts: the client can change them meanwhile.
+type transferSettings struct {
+	ascii    bool
+	deflate  bool
+	level    int
+	typeName string
+}
+
+// transferSettings returns the current TYPE and MODE
+func (ses *Session) transferSettings() transferSettings {
+	return transferSettings{
+		ascii:    ses.transferType == typeASCII,
+		deflate:  ses.transferMode == modeDeflate,
+		level:    ses.deflateLevel,
+		typeName: ses.transferTypeName(),
+	}
+}
+
+// dataWriter wraps the data connection writer according
+// to the transfer mode. The returned function must be called
+// at the end of a successful transfer to flush the stream.
+func (ts transferSettings) dataWriter(w io.Writer) (io.Writer, func() error, error) {
+	if !ts.deflate {
+		return w, func() error { return nil }, nil
+	}
+
+	zw, err := datachannel.NewDeflateWriter(w, ts.level)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return zw, zw.Close, nil
+}
+
+// dataReader wraps the data connection reader according
+// to the transfer mode
+func (ts transferSettings) dataReader(r io.Reader) (io.Reader, error) {
+	if !ts.deflate {
+		return r, nil
+	}
+
+	return datachannel.NewDeflateReader(r)
+}
+
 func clearPath(s string) string {
 	if s == ".." {
 		return s
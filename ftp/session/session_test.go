@@ -1,11 +1,33 @@
 package session
 
 import (
+	"io/ioutil"
 	"testing"
+	"time"
 
+	"github.com/mindflavor/ftpserver2/ftp/fs/localFS"
+	"github.com/mindflavor/ftpserver2/ftp/metrics"
+	"github.com/mindflavor/ftpserver2/identity/basic"
 	"github.com/stretchr/testify/assert"
 )
 
+// newFSTestSession returns a session logged in as alice
+// on a localFS rooted in a new temporary directory.
+// The caller removes the directory.
+func newFSTestSession(t *testing.T) (*Session, *fakeConn, string) {
+	dir, err := ioutil.TempDir("", "session")
+	assert.NoError(t, err)
+
+	fp, err := localFS.New(dir)
+	assert.NoError(t, err)
+
+	conn := newFakeConn("10.0.0.1", false)
+	ses := New(conn, nil, time.Minute, nil, func(username, password string) bool { return true }, metrics.WrapFileProvider(fp, metrics.New()))
+	ses.id = basicidentity.New("alice", true)
+
+	return ses, conn, dir
+}
+
 func Test_splitAndClearPathPlain(t *testing.T) {
 	received := splitAndClearPath("/root/test/third")
 	expected := []string{"root", "test", "third"}
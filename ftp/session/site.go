@@ -0,0 +1,164 @@
+package session
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/mindflavor/ftpserver2/ftp/fs"
+	"github.com/mindflavor/ftpserver2/identity"
+)
+
+// utimeLayout is the SITE UTIME (and MDTM) time format
+const utimeLayout = "20060102150405"
+
+// builtinSITECommands returns the SITE
+// subcommands implemented by the session
+func builtinSITECommands() []Command {
+	return []Command{
+		{Verb: "CHMOD", Func: (*Session).processSITECHMOD, Requirements: RequireAuth, Permission: identity.PermissionWrite},
+		{Verb: "UTIME", Func: (*Session).processSITEUTIME, Requirements: RequireAuth, Permission: identity.PermissionWrite},
+		{Verb: "CHOWN", Func: (*Session).processSITECHOWN, Requirements: RequireAuth},
+	}
+}
+
+// SITE CHMOD <octal mode> <path>
+func (ses *Session) processSITECHMOD(tokens []string) bool {
+	log.WithFields(log.Fields{"ses": ses, "tokens": tokens, "command": "SITE CHMOD"}).Info("session::Session::processSITECHMOD method begin")
+
+	mc := ses.modeChanger()
+	if mc == nil {
+		ses.sendStatement("502 SITE CHMOD not supported by the file system")
+		return false
+	}
+
+	if len(tokens) < 3 {
+		ses.sendStatement("501 SITE CHMOD <mode> <path>")
+		return false
+	}
+
+	mode, err := strconv.ParseUint(tokens[1], 8, 32)
+	if err != nil || mode > 0777 {
+		ses.sendStatement(fmt.Sprintf("501 invalid mode %s", tokens[1]))
+		return false
+	}
+
+	path := strings.Join(tokens[2:], " ")
+	if err := mc.Chmod(path, os.FileMode(mode)); err != nil {
+		ses.sendStatement(fmt.Sprintf("550 cannot change the mode of %s (%s)", path, err))
+		return false
+	}
+
+	ses.sendStatement("200 SITE CHMOD command successful.")
+	return false
+}
+
+// SITE UTIME <YYYYMMDDhhmmss> <path> or
+// SITE UTIME <path> <atime> <mtime> <ctime> UTC.
+// Times are UTC, only the modification time is set.
+func (ses *Session) processSITEUTIME(tokens []string) bool {
+	log.WithFields(log.Fields{"ses": ses, "tokens": tokens, "command": "SITE UTIME"}).Info("session::Session::processSITEUTIME method begin")
+
+	tc := ses.timesChanger()
+	if tc == nil {
+		ses.sendStatement("502 SITE UTIME not supported by the file system")
+		return false
+	}
+
+	var path, value string
+	n := len(tokens)
+	switch {
+	case n >= 6 && strings.ToUpper(tokens[n-1]) == "UTC":
+		path = strings.Join(tokens[1:n-4], " ")
+		value = tokens[n-3]
+	case n >= 3:
+		path = strings.Join(tokens[2:], " ")
+		value = tokens[1]
+	default:
+		ses.sendStatement("501 SITE UTIME <YYYYMMDDhhmmss> <path>")
+		return false
+	}
+
+	mtime, err := time.Parse(utimeLayout, value)
+	if err != nil {
+		ses.sendStatement(fmt.Sprintf("501 invalid time %s", value))
+		return false
+	}
+
+	if err := tc.Chtimes(path, mtime); err != nil {
+		ses.sendStatement(fmt.Sprintf("550 cannot change the time of %s (%s)", path, err))
+		return false
+	}
+
+	ses.sendStatement("200 SITE UTIME command successful.")
+	return false
+}
+
+// SITE CHOWN <owner>[:<group>] <path>. Only the users
+// explicitly granted identity.PermissionChown can use it.
+func (ses *Session) processSITECHOWN(tokens []string) bool {
+	log.WithFields(log.Fields{"ses": ses, "tokens": tokens, "command": "SITE CHOWN"}).Info("session::Session::processSITECHOWN method begin")
+
+	if az, ok := ses.id.(identity.Authorizer); !ok || !az.HasPermission(identity.PermissionChown) {
+		ses.sendStatement("550 Permission denied.")
+		return false
+	}
+
+	oc := ses.ownerChanger()
+	if oc == nil {
+		ses.sendStatement("502 SITE CHOWN not supported by the file system")
+		return false
+	}
+
+	if len(tokens) < 3 {
+		ses.sendStatement("501 SITE CHOWN <owner>[:<group>] <path>")
+		return false
+	}
+
+	owner, group := tokens[1], ""
+	if i := strings.Index(owner, ":"); i >= 0 {
+		owner, group = owner[:i], owner[i+1:]
+	}
+
+	path := strings.Join(tokens[2:], " ")
+	if err := oc.Chown(path, owner, group); err != nil {
+		ses.sendStatement(fmt.Sprintf("550 cannot change the owner of %s (%s)", path, err))
+		return false
+	}
+
+	ses.sendStatement("200 SITE CHOWN command successful.")
+	return false
+}
+
+// modeChanger returns the fs.ModeChanger capability
+// of the FileProvider, nil if not supported
+func (ses *Session) modeChanger() fs.ModeChanger {
+	if _, ok := fs.Unwrap(ses.fileProvider).(fs.ModeChanger); !ok {
+		return nil
+	}
+
+	return ses.fileProvider.(fs.ModeChanger)
+}
+
+// timesChanger returns the fs.TimesChanger capability
+// of the FileProvider, nil if not supported
+func (ses *Session) timesChanger() fs.TimesChanger {
+	if _, ok := fs.Unwrap(ses.fileProvider).(fs.TimesChanger); !ok {
+		return nil
+	}
+
+	return ses.fileProvider.(fs.TimesChanger)
+}
+
+// ownerChanger returns the fs.OwnerChanger capability
+// of the FileProvider, nil if not supported
+func (ses *Session) ownerChanger() fs.OwnerChanger {
+	if _, ok := fs.Unwrap(ses.fileProvider).(fs.OwnerChanger); !ok {
+		return nil
+	}
+
+	return ses.fileProvider.(fs.OwnerChanger)
+}
@@ -0,0 +1,100 @@
+package session
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mindflavor/ftpserver2/identity"
+	"github.com/mindflavor/ftpserver2/identity/basic"
+	"github.com/stretchr/testify/assert"
+)
+
+// privileged is an identity.Authorizer
+// granting every permission
+type privileged struct {
+	identity.Identity
+}
+
+func (privileged) HasPermission(permission string) bool {
+	return true
+}
+
+func TestSITECHMOD(t *testing.T) {
+	ses, conn, dir := newFSTestSession(t)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "deploy.sh")
+	assert.NoError(t, ioutil.WriteFile(file, []byte("#!/bin/sh\n"), 0600))
+
+	ses.dispatch([]string{"SITE", "CHMOD", "755", "deploy.sh"})
+	assert.Equal(t, "200 SITE CHMOD command successful.", conn.lastReply())
+
+	st, err := os.Stat(file)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), st.Mode().Perm())
+
+	ses.dispatch([]string{"SITE", "CHMOD", "4755", "deploy.sh"})
+	assert.True(t, strings.HasPrefix(conn.lastReply(), "501"))
+
+	ses.dispatch([]string{"SITE", "CHMOD", "644", "../outside"})
+	assert.True(t, strings.HasPrefix(conn.lastReply(), "550"))
+}
+
+func TestSITEUTIME(t *testing.T) {
+	ses, conn, dir := newFSTestSession(t)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "my file.txt")
+	assert.NoError(t, ioutil.WriteFile(file, []byte("hello"), 0600))
+
+	ses.dispatch([]string{"SITE", "UTIME", "20160305090402", "my", "file.txt"})
+	assert.Equal(t, "200 SITE UTIME command successful.", conn.lastReply())
+
+	st, err := os.Stat(file)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2016, time.March, 5, 9, 4, 2, 0, time.UTC), st.ModTime().UTC())
+
+	ses.dispatch([]string{"SITE", "UTIME", "my", "file.txt", "20170101000000", "20170102000000", "20170101000000", "UTC"})
+	assert.Equal(t, "200 SITE UTIME command successful.", conn.lastReply())
+
+	st, err = os.Stat(file)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2017, time.January, 2, 0, 0, 0, 0, time.UTC), st.ModTime().UTC())
+
+	ses.dispatch([]string{"SITE", "UTIME", "yesterday", "my", "file.txt"})
+	assert.True(t, strings.HasPrefix(conn.lastReply(), "501"))
+}
+
+func TestSITECHOWN(t *testing.T) {
+	ses, conn, dir := newFSTestSession(t)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "file.txt")
+	assert.NoError(t, ioutil.WriteFile(file, []byte("hello"), 0600))
+
+	ses.dispatch([]string{"SITE", "CHOWN", "root", "file.txt"})
+	assert.Equal(t, "550 Permission denied.", conn.lastReply())
+
+	// chown to ourselves is always allowed
+	ses.id = privileged{ses.id}
+	ses.dispatch([]string{"SITE", "CHOWN", strconv.Itoa(os.Getuid()) + ":" + strconv.Itoa(os.Getgid()), "file.txt"})
+	assert.Equal(t, "200 SITE CHOWN command successful.", conn.lastReply())
+}
+
+func TestSITEUnsupported(t *testing.T) {
+	conn := newFakeConn("10.0.0.1", false)
+	ses := newTestSession(conn)
+	ses.id = privileged{basicidentity.New("alice", true)}
+
+	ses.dispatch([]string{"SITE", "CHMOD", "755", "file"})
+	assert.Equal(t, "502 SITE CHMOD not supported by the file system", conn.lastReply())
+	ses.dispatch([]string{"SITE", "UTIME", "20160305090402", "file"})
+	assert.Equal(t, "502 SITE UTIME not supported by the file system", conn.lastReply())
+	ses.dispatch([]string{"SITE", "CHOWN", "root", "file"})
+	assert.Equal(t, "502 SITE CHOWN not supported by the file system", conn.lastReply())
+}
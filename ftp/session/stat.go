@@ -0,0 +1,139 @@
+package session
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/mindflavor/ftpserver2/identity"
+)
+
+// transfer is the data transfer in progress,
+// shown by STAT. bytes is updated atomically
+// by the data connection go routine.
+type transfer struct {
+	direction string
+	path      string
+	started   time.Time
+	bytes     int64
+}
+
+// add counts n more bytes transferred
+func (t *transfer) add(n int) {
+	atomic.AddInt64(&t.bytes, int64(n))
+}
+
+// startTransfer records the transfer in progress
+func (ses *Session) startTransfer(direction, path string) *transfer {
+	t := &transfer{direction: direction, path: path, started: time.Now()}
+
+	ses.transferMutex.Lock()
+	defer ses.transferMutex.Unlock()
+	ses.transfer = t
+
+	return t
+}
+
+// endTransfer clears t if still
+// the transfer in progress
+func (ses *Session) endTransfer(t *transfer) {
+	ses.transferMutex.Lock()
+	defer ses.transferMutex.Unlock()
+
+	if ses.transfer == t {
+		ses.transfer = nil
+	}
+}
+
+// currentTransfer returns the transfer
+// in progress, nil if none
+func (ses *Session) currentTransfer() *transfer {
+	ses.transferMutex.Lock()
+	defer ses.transferMutex.Unlock()
+
+	return ses.transfer
+}
+
+// STAT shows the session status. STAT <path>
+// lists path over the control connection,
+// accepting the same flags of LIST.
+func (ses *Session) processSTAT(tokens []string) bool {
+	log.WithFields(log.Fields{"ses": ses, "tokens": tokens, "command": "STAT"}).Info("session::Session::processSTAT method begin")
+
+	if len(tokens) < 2 {
+		ses.sendStatement(ses.status())
+		return false
+	}
+
+	if az, ok := ses.id.(identity.Authorizer); ok && !az.HasPermission(identity.PermissionList) {
+		ses.sendStatement("550 Permission denied.")
+		return false
+	}
+
+	opts, arg := parseListArgs(tokens[1:])
+	opts.long = true
+
+	l := ses.newListing(opts)
+	if err := l.prepare(arg); err != nil {
+		ses.sendStatement(fmt.Sprintf("550 cannot retrieve directory list: %s", err))
+		return false
+	}
+
+	buf := new(bytes.Buffer)
+	buf.WriteString(fmt.Sprintf("213-Status of %s:\r\n", strings.Join(tokens[1:], " ")))
+	if err := l.write(buf); err != nil {
+		ses.sendStatement(fmt.Sprintf("550 cannot retrieve directory list: %s", err))
+		return false
+	}
+
+	if l.truncated {
+		buf.WriteString(fmt.Sprintf("213 End of status (truncated at %d entries)", ses.listLimits.MaxEntries))
+	} else {
+		buf.WriteString("213 End of status")
+	}
+
+	ses.sendStatement(buf.String())
+	return false
+}
+
+// status returns the STAT reply
+// describing the session state
+func (ses *Session) status() string {
+	buf := new(bytes.Buffer)
+
+	buf.WriteString("211-FTP server status:\r\n")
+	buf.WriteString(fmt.Sprintf(" Connected from %s\r\n", ses.remoteIP()))
+	buf.WriteString(fmt.Sprintf(" Logged in as %s\r\n", ses.id.Username()))
+
+	if ses.conn.IsSecure() {
+		buf.WriteString(" Control connection encrypted (TLS)\r\n")
+	} else {
+		buf.WriteString(" Control connection in plain text\r\n")
+	}
+
+	if ses.dataChannelEncryption {
+		buf.WriteString(" Data connections encrypted (PROT P)\r\n")
+	} else {
+		buf.WriteString(" Data connections in plain text (PROT C)\r\n")
+	}
+
+	mode := "STREAM"
+	if ses.transferMode == modeDeflate {
+		mode = "DEFLATE"
+	}
+	buf.WriteString(fmt.Sprintf(" TYPE: %s, MODE: %s\r\n", ses.transferTypeName(), mode))
+	buf.WriteString(fmt.Sprintf(" Current directory: %s\r\n", ses.fileProvider.CurrentDirectory()))
+	buf.WriteString(fmt.Sprintf(" REST offset: %d\r\n", ses.lastREST))
+
+	if t := ses.currentTransfer(); t != nil {
+		buf.WriteString(fmt.Sprintf(" Transfer in progress: %s %s, %d bytes in %s\r\n", t.direction, t.path, atomic.LoadInt64(&t.bytes), time.Since(t.started).Truncate(time.Second)))
+	} else {
+		buf.WriteString(" No transfer in progress\r\n")
+	}
+
+	buf.WriteString("211 End of status")
+	return buf.String()
+}
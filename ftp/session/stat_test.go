@@ -0,0 +1,72 @@
+package session
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mindflavor/ftpserver2/ftp/auditlog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSTATStatus(t *testing.T) {
+	ses, conn, dir := newFSTestSession(t)
+	defer os.RemoveAll(dir)
+
+	ses.transferType = typeASCII
+	ses.lastREST = 1024
+
+	ses.dispatch([]string{"STAT"})
+	out := conn.out.String()
+	assert.True(t, strings.HasPrefix(out, "211-FTP server status:\r\n"))
+	assert.Contains(t, out, " Connected from 10.0.0.1\r\n")
+	assert.Contains(t, out, " Logged in as alice\r\n")
+	assert.Contains(t, out, " Control connection in plain text\r\n")
+	assert.Contains(t, out, " Data connections in plain text (PROT C)\r\n")
+	assert.Contains(t, out, " TYPE: ASCII, MODE: STREAM\r\n")
+	assert.Contains(t, out, " Current directory: /\r\n")
+	assert.Contains(t, out, " REST offset: 1024\r\n")
+	assert.Contains(t, out, " No transfer in progress\r\n")
+	assert.Equal(t, "211 End of status", conn.lastReply())
+
+	// STAT does not reset REST
+	assert.Equal(t, int64(1024), ses.lastREST)
+
+	tr := ses.startTransfer(auditlog.Download, "/big.iso")
+	tr.add(4096)
+	conn.out.Reset()
+	ses.dispatch([]string{"STAT"})
+	assert.Contains(t, conn.out.String(), " Transfer in progress: download /big.iso, 4096 bytes in ")
+
+	ses.endTransfer(tr)
+	conn.out.Reset()
+	ses.dispatch([]string{"STAT"})
+	assert.Contains(t, conn.out.String(), " No transfer in progress\r\n")
+}
+
+func TestSTATPath(t *testing.T) {
+	ses, conn, dir := newFSTestSession(t)
+	defer os.RemoveAll(dir)
+
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, "reports"), 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "reports", "march.csv"), []byte("a,b"), 0644))
+
+	ses.dispatch([]string{"STAT", "reports"})
+	lines := strings.Split(strings.TrimSuffix(conn.out.String(), "\r\n"), "\r\n")
+	assert.Len(t, lines, 3)
+	assert.Equal(t, "213-Status of reports:", lines[0])
+	assert.True(t, strings.HasPrefix(lines[1], "-rw-r--r--"))
+	assert.True(t, strings.HasSuffix(lines[1], " march.csv"))
+	assert.Equal(t, "213 End of status", lines[2])
+
+	conn.out.Reset()
+	ses.dispatch([]string{"STAT", "-a", "reports/*.csv"})
+	assert.Contains(t, conn.out.String(), " reports/march.csv\r\n213 End of status")
+
+	conn.out.Reset()
+	ses.dispatch([]string{"STAT", "missing"})
+	assert.True(t, strings.HasPrefix(conn.lastReply(), "550"))
+	assert.Equal(t, "/", ses.fileProvider.CurrentDirectory())
+}
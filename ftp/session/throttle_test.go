@@ -0,0 +1,41 @@
+package session
+
+import (
+	"testing"
+
+	"github.com/mindflavor/ftpserver2/identity"
+	"github.com/mindflavor/ftpserver2/identity/basic"
+	"github.com/stretchr/testify/assert"
+)
+
+// limitedIdentity is an identity.BandwidthLimited
+type limitedIdentity struct {
+	identity.Identity
+}
+
+func (limitedIdentity) DownloadLimit() int64 { return 1000 }
+func (limitedIdentity) UploadLimit() int64   { return 2000 }
+
+func TestIdentityLimitsTakePrecedence(t *testing.T) {
+	ses := newTestSession(newFakeConn("10.0.0.1", false))
+	ses.SetIdentityAuthenticator(func(name, password string) identity.Identity {
+		return limitedIdentity{basicidentity.New(name, false)}
+	})
+
+	// the server updates the limits from its own go routine
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			ses.SetBandwidthLimits(5, 5)
+		}
+	}()
+
+	ses.dispatch([]string{"USER", "bob"})
+	ses.dispatch([]string{"PASS", "secret"})
+	<-done
+
+	ses.SetBandwidthLimits(5, 5)
+	assert.Equal(t, int64(1000), ses.downloadLimiter.Rate())
+	assert.Equal(t, int64(2000), ses.uploadLimiter.Rate())
+}
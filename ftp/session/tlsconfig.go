@@ -0,0 +1,63 @@
+package session
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+)
+
+// BindTLSConfig returns a clone of base to be used by the
+// control and data connections of a single session. The TLS
+// session tickets it issues carry a random token and only the
+// tickets carrying it can be resumed: a data connection resumes
+// its TLS session only if it comes from the same client that
+// owns the control connection. base should have its session
+// ticket keys set (see tls.Config.SetSessionTicketKeys) so the
+// clones can decrypt each other's tickets.
+// It returns nil if base is nil.
+func BindTLSConfig(base *tls.Config) *tls.Config {
+	if base == nil {
+		return nil
+	}
+
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		panic(err)
+	}
+
+	cfg := base.Clone()
+
+	wrap := base.WrapSession
+	cfg.WrapSession = func(cs tls.ConnectionState, ss *tls.SessionState) ([]byte, error) {
+		ss.Extra = append(ss.Extra, token)
+		if wrap != nil {
+			return wrap(cs, ss)
+		}
+		return cfg.EncryptTicket(cs, ss)
+	}
+
+	unwrap := base.UnwrapSession
+	cfg.UnwrapSession = func(identity []byte, cs tls.ConnectionState) (*tls.SessionState, error) {
+		var ss *tls.SessionState
+		var err error
+		if unwrap != nil {
+			ss, err = unwrap(identity, cs)
+		} else {
+			ss, err = cfg.DecryptTicket(identity, cs)
+		}
+		if err != nil || ss == nil {
+			return nil, err
+		}
+
+		for _, extra := range ss.Extra {
+			if bytes.Equal(extra, token) {
+				return ss, nil
+			}
+		}
+
+		// someone else's ticket: fall back to a full handshake
+		return nil, nil
+	}
+
+	return cfg
+}
@@ -0,0 +1,84 @@
+package session
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testCertificate(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// handshake connects a client to a server using
+// cfg and returns true if the TLS session was resumed
+func handshake(t *testing.T, cfg *tls.Config, clientCfg *tls.Config) bool {
+	sc, cc := net.Pipe()
+	defer sc.Close()
+	defer cc.Close()
+
+	resumed := make(chan bool)
+	go func() {
+		srv := tls.Server(sc, cfg)
+		if err := srv.Handshake(); err != nil {
+			resumed <- false
+			return
+		}
+		srv.Write([]byte("x"))
+		resumed <- srv.ConnectionState().DidResume
+	}()
+
+	client := tls.Client(cc, clientCfg)
+	buf := make([]byte, 1)
+	// reading processes the session tickets too
+	_, err := client.Read(buf)
+	assert.NoError(t, err)
+
+	return <-resumed
+}
+
+func TestBindTLSConfig(t *testing.T) {
+	base := &tls.Config{Certificates: []tls.Certificate{testCertificate(t)}}
+	base.SetSessionTicketKeys([][32]byte{{1, 2, 3}})
+
+	clientCfg := &tls.Config{
+		InsecureSkipVerify: true,
+		ServerName:         "localhost",
+		ClientSessionCache: tls.NewLRUClientSessionCache(4),
+	}
+
+	control := BindTLSConfig(base)
+	assert.False(t, handshake(t, control, clientCfg))
+
+	// data connection of the same session
+	assert.True(t, handshake(t, control, clientCfg))
+
+	// another session cannot resume it
+	other := BindTLSConfig(base)
+	assert.False(t, handshake(t, other, clientCfg))
+
+	assert.Nil(t, BindTLSConfig(nil))
+}
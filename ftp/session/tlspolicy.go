@@ -0,0 +1,59 @@
+package session
+
+import (
+	"net"
+)
+
+// TLSPolicy forces the clients to use
+// the FTPS extensions (RFC 4217)
+type TLSPolicy struct {
+	// RequireControl refuses USER and PASS until
+	// the control connection is encrypted (AUTH TLS)
+	RequireControl bool
+	// RequireData refuses PROT C and the data
+	// transfers until the client sends PROT P
+	RequireData bool
+	// PlaintextIPs are the client networks
+	// exempted from the policy
+	PlaintextIPs []*net.IPNet
+	// PlaintextUsers are the users exempted
+	// from the policy
+	PlaintextUsers []string
+}
+
+// plaintextAllowed returns true if the
+// client is exempted from the TLS policy
+// (either by IP or by username)
+func (ses *Session) plaintextAllowed(username string) bool {
+	ip := net.ParseIP(ses.remoteIP())
+	for _, n := range ses.tlsPolicy.PlaintextIPs {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	if username == "" {
+		return false
+	}
+
+	for _, u := range ses.tlsPolicy.PlaintextUsers {
+		if u == username {
+			return true
+		}
+	}
+
+	return false
+}
+
+// controlTLSRequired returns true if the
+// command connection must be encrypted
+// before logging in as username
+func (ses *Session) controlTLSRequired(username string) bool {
+	return ses.tlsPolicy.RequireControl && !ses.conn.IsSecure() && !ses.plaintextAllowed(username)
+}
+
+// dataTLSRequired returns true if the
+// data connections must be encrypted
+func (ses *Session) dataTLSRequired() bool {
+	return ses.tlsPolicy.RequireData && !ses.plaintextAllowed(ses.id.Username())
+}
@@ -0,0 +1,113 @@
+package session
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeConn is an in memory securableConn.Conn
+type fakeConn struct {
+	out    bytes.Buffer
+	bufw   *bufio.Writer
+	bufr   *bufio.Reader
+	remote net.Addr
+	secure bool
+	state  *tls.ConnectionState
+}
+
+func newFakeConn(remoteIP string, secure bool) *fakeConn {
+	c := &fakeConn{
+		remote: &net.TCPAddr{IP: net.ParseIP(remoteIP), Port: 4000},
+		secure: secure,
+		bufr:   bufio.NewReader(strings.NewReader("")),
+	}
+	c.bufw = bufio.NewWriter(&c.out)
+	return c
+}
+
+func (c *fakeConn) Close() error          { return nil }
+func (c *fakeConn) SwitchToTLS() error    { c.secure = true; return nil }
+func (c *fakeConn) SwitchToPlain() error  { c.secure = false; return nil }
+func (c *fakeConn) LocalAddr() net.Addr   { return &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 21} }
+func (c *fakeConn) RemoteAddr() net.Addr  { return c.remote }
+func (c *fakeConn) Writer() *bufio.Writer { return c.bufw }
+func (c *fakeConn) Reader() *bufio.Reader { return c.bufr }
+func (c *fakeConn) IsSecure() bool        { return c.secure }
+func (c *fakeConn) ConnectionState() *tls.ConnectionState {
+	return c.state
+}
+
+// lastReply returns the last reply sent by the session
+func (c *fakeConn) lastReply() string {
+	lines := strings.Split(strings.TrimRight(c.out.String(), "\r\n"), "\r\n")
+	return lines[len(lines)-1]
+}
+
+func newTestSession(conn *fakeConn) *Session {
+	return New(conn, nil, time.Minute, nil, func(username, password string) bool { return true }, nil)
+}
+
+func TestTLSPolicyControl(t *testing.T) {
+	_, lan, _ := net.ParseCIDR("192.168.0.0/16")
+	policy := TLSPolicy{
+		RequireControl: true,
+		PlaintextIPs:   []*net.IPNet{lan},
+		PlaintextUsers: []string{"legacy"},
+	}
+
+	conn := newFakeConn("10.0.0.1", false)
+	ses := newTestSession(conn)
+	ses.SetTLSPolicy(policy)
+
+	newCmdList(ses, []string{"USER", "test"}, ses.processUSER).requireTLS().Execute()
+	assert.True(t, strings.HasPrefix(conn.lastReply(), "530"))
+
+	newCmdList(ses, []string{"USER", "legacy"}, ses.processUSER).requireTLS().Execute()
+	assert.True(t, strings.HasPrefix(conn.lastReply(), "331"))
+
+	conn = newFakeConn("192.168.1.1", false)
+	ses = newTestSession(conn)
+	ses.SetTLSPolicy(policy)
+	newCmdList(ses, []string{"USER", "test"}, ses.processUSER).requireTLS().Execute()
+	assert.True(t, strings.HasPrefix(conn.lastReply(), "331"))
+
+	conn = newFakeConn("10.0.0.1", true)
+	ses = newTestSession(conn)
+	ses.SetTLSPolicy(policy)
+	newCmdList(ses, []string{"USER", "test"}, ses.processUSER).requireTLS().Execute()
+	assert.True(t, strings.HasPrefix(conn.lastReply(), "331"))
+}
+
+func TestTLSPolicyData(t *testing.T) {
+	conn := newFakeConn("10.0.0.1", true)
+	ses := newTestSession(conn)
+	ses.SetTLSPolicy(TLSPolicy{RequireData: true})
+
+	executed := false
+	pe := func(tokens []string) bool {
+		executed = true
+		return false
+	}
+
+	newCmdList(ses, []string{"RETR", "file"}, pe).requirePROT().Execute()
+	assert.False(t, executed)
+	assert.True(t, strings.HasPrefix(conn.lastReply(), "521"))
+
+	ses.processPROT([]string{"PROT", "P"})
+	assert.True(t, strings.HasPrefix(conn.lastReply(), "503"))
+
+	ses.processPBSZ([]string{"PBSZ", "0"})
+	ses.processPROT([]string{"PROT", "C"})
+	assert.True(t, strings.HasPrefix(conn.lastReply(), "534"))
+
+	ses.processPROT([]string{"PROT", "P"})
+	newCmdList(ses, []string{"RETR", "file"}, pe).requirePROT().Execute()
+	assert.True(t, executed)
+}
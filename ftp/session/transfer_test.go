@@ -0,0 +1,246 @@
+package session
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mindflavor/ftpserver2/ftp/auditlog"
+	"github.com/mindflavor/ftpserver2/ftp/datachannel"
+	"github.com/mindflavor/ftpserver2/ftp/fs"
+	"github.com/mindflavor/ftpserver2/ftp/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+// pipeDataChannel is an in memory
+// datachannel.DataChanneler: the
+// test plays the client on client
+type pipeDataChannel struct {
+	server net.Conn
+	client net.Conn
+	mutex  sync.Mutex
+	closed bool
+	done   chan struct{}
+	// read and written count the bytes
+	// seen by the SinkFunction
+	read     int64
+	written  int64
+	transfer datachannel.TransferFunc
+}
+
+func newPipeDataChannel() *pipeDataChannel {
+	server, client := net.Pipe()
+	return &pipeDataChannel{server: server, client: client, done: make(chan struct{})}
+}
+
+func (dc *pipeDataChannel) Close() error {
+	dc.mutex.Lock()
+	defer dc.mutex.Unlock()
+
+	dc.closed = true
+	return dc.server.Close()
+}
+
+func (dc *pipeDataChannel) IsClosed() bool {
+	dc.mutex.Lock()
+	defer dc.mutex.Unlock()
+
+	return dc.closed
+}
+
+func (dc *pipeDataChannel) Sink(f datachannel.SinkFunction) {
+	go func() {
+		defer close(dc.done)
+
+		start := time.Now()
+		f(writeCounter{dc.server, &dc.written}, readCounter{dc.server, &dc.read})
+		if dc.transfer != nil {
+			dc.transfer(dc.read, dc.written, time.Since(start))
+		}
+	}()
+}
+
+func (dc *pipeDataChannel) Port() int                             { return 0 }
+func (dc *pipeDataChannel) ToPASVStringPort() string              { return "" }
+func (dc *pipeDataChannel) Open() error                           { return nil }
+func (dc *pipeDataChannel) Encrypted() bool                       { return false }
+func (dc *pipeDataChannel) SetEncrypted(encrypt bool)             {}
+func (dc *pipeDataChannel) OnRefused(f func(err error))           {}
+func (dc *pipeDataChannel) OnTransfer(f datachannel.TransferFunc) { dc.transfer = f }
+
+type readCounter struct {
+	r io.Reader
+	n *int64
+}
+
+func (c readCounter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	*c.n += int64(n)
+	return n, err
+}
+
+type writeCounter struct {
+	w io.Writer
+	n *int64
+}
+
+func (c writeCounter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	*c.n += int64(n)
+	return n, err
+}
+
+// replies collects the lines sent by the session
+// so they can be read from another go routine
+type replies chan string
+
+func (r replies) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\r\n"), "\r\n") {
+		r <- line
+	}
+	return len(p), nil
+}
+
+func collectReplies(conn *fakeConn) replies {
+	r := make(replies, 64)
+	conn.bufw = bufio.NewWriter(r)
+	return r
+}
+
+// next returns the next reply line,
+// failing the test if none comes in time
+func (r replies) next(t *testing.T) string {
+	select {
+	case line := <-r:
+		return line
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reply from the session")
+		return ""
+	}
+}
+
+func TestSTORDeflate(t *testing.T) {
+	ses, conn, dir := newFSTestSession(t)
+	defer os.RemoveAll(dir)
+	replies := collectReplies(conn)
+
+	ses.dispatch([]string{"MODE", "Z"})
+	assert.True(t, strings.HasPrefix(replies.next(t), "200"))
+
+	dc := newPipeDataChannel()
+	ses.lastDataChanneler = dc
+
+	// the client sends the zlib header only after the 150 reply
+	go ses.dispatch([]string{"STOR", "up.txt"})
+	assert.True(t, strings.HasPrefix(replies.next(t), "150"))
+
+	payload := strings.Repeat("compressed upload\r\n", 100)
+	buf := new(bytes.Buffer)
+	w := zlib.NewWriter(buf)
+	w.Write([]byte(payload))
+	w.Close()
+
+	go func() {
+		dc.client.Write(buf.Bytes())
+		dc.client.Close()
+	}()
+
+	assert.True(t, strings.HasPrefix(replies.next(t), "226"))
+	<-dc.done
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "up.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, payload, string(b))
+}
+
+func TestTransferMetrics(t *testing.T) {
+	ses, conn, dir := newFSTestSession(t)
+	defer os.RemoveAll(dir)
+	replies := collectReplies(conn)
+
+	m := metrics.New()
+	ses.SetMetrics(m)
+
+	// an empty upload is still an upload
+	dc := newPipeDataChannel()
+	ses.lastDataChanneler = dc
+	ses.expectReply("STOR")
+	ses.dispatch([]string{"STOR", "empty.txt"})
+	assert.True(t, strings.HasPrefix(replies.next(t), "150"))
+	dc.client.Close()
+	assert.True(t, strings.HasPrefix(replies.next(t), "226"))
+	<-dc.done
+
+	buf := new(bytes.Buffer)
+	m.WriteTo(buf)
+	assert.Contains(t, buf.String(), `ftp_transfer_duration_seconds_count{direction="upload"} 1`)
+	assert.NotContains(t, buf.String(), `direction="download"`)
+
+	// the final reply is counted, not the 150
+	assert.Contains(t, buf.String(), `ftp_commands_total{code="226",command="STOR"} 1`)
+	assert.NotContains(t, buf.String(), `code="150"`)
+}
+
+func TestRETRChecksum(t *testing.T) {
+	ses, conn, dir := newFSTestSession(t)
+	defer os.RemoveAll(dir)
+	replies := collectReplies(conn)
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "down.txt"), []byte("hello"), 0600))
+
+	retr := func() string {
+		dc := newPipeDataChannel()
+		ses.lastDataChanneler = dc
+		ses.dispatch([]string{"RETR", "down.txt"})
+		assert.True(t, strings.HasPrefix(replies.next(t), "150"))
+		b, err := ioutil.ReadAll(dc.client)
+		assert.NoError(t, err)
+		reply := replies.next(t)
+		<-dc.done
+		assert.Equal(t, "hello", string(b))
+		return reply
+	}
+
+	// no audit log: the digest is not computed at all
+	ses.hashAlgorithm = "unsupported"
+	assert.Equal(t, "226 File send OK.", retr())
+
+	ses.hashAlgorithm = fs.HashSHA256
+	buf := new(bytes.Buffer)
+	ses.SetAuditLog(auditlog.New(buf, auditlog.FormatJSON))
+	assert.Equal(t, "226 File send OK.", retr())
+	assert.Contains(t, buf.String(), `"checksum_algorithm":"SHA-256","checksum":"2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"`)
+}
+
+func TestTYPEDuringTransfer(t *testing.T) {
+	ses, conn, dir := newFSTestSession(t)
+	defer os.RemoveAll(dir)
+	replies := collectReplies(conn)
+
+	dc := newPipeDataChannel()
+	ses.lastDataChanneler = dc
+	ses.dispatch([]string{"STOR", "binary.dat"})
+	assert.Equal(t, "150 Opening BINARY mode data connection for binary.dat.", replies.next(t))
+
+	// the transfer keeps the TYPE it started with
+	ses.dispatch([]string{"TYPE", "A"})
+	assert.True(t, strings.HasPrefix(replies.next(t), "200"))
+
+	dc.client.Write([]byte("line\r\n"))
+	dc.client.Close()
+	assert.True(t, strings.HasPrefix(replies.next(t), "226"))
+	<-dc.done
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "binary.dat"))
+	assert.NoError(t, err)
+	assert.Equal(t, "line\r\n", string(b))
+}
@@ -0,0 +1,248 @@
+// Package throttle implements a token bucket
+// rate limiter and the io.Reader/io.Writer wrappers
+// used to limit the data channel bandwidth.
+package throttle
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxChunk is the maximum number of bytes
+// read or written in a single call by the wrappers.
+// Smaller chunks give a smoother rate.
+const maxChunk = 32 * 1024
+
+// maxSegment is the number of bytes copied by ReadFrom
+// between two checks of the rates while unlimited
+const maxSegment = 4 * 1024 * 1024
+
+// Limiter is a token bucket rate limiter.
+// A Limiter can be shared between goroutines (for example
+// a global limit shared by every session) and its rate
+// can be changed at any time. A rate of 0 means unlimited.
+type Limiter struct {
+	mu     sync.Mutex
+	rate   int64 // atomic: read without mu while unlimited
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter creates a new Limiter allowing
+// bytesPerSecond bytes per second (0 for unlimited).
+func NewLimiter(bytesPerSecond int64) *Limiter {
+	return &Limiter{
+		rate:   bytesPerSecond,
+		tokens: float64(bytesPerSecond),
+		last:   time.Now(),
+	}
+}
+
+// Rate returns the current rate in bytes per second
+func (l *Limiter) Rate() int64 {
+	return atomic.LoadInt64(&l.rate)
+}
+
+// SetRate changes the rate. It's effective
+// immediately, even for the transfers in progress.
+func (l *Limiter) SetRate(bytesPerSecond int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	atomic.StoreInt64(&l.rate, bytesPerSecond)
+	l.last = time.Now()
+	if l.tokens > float64(bytesPerSecond) {
+		l.tokens = float64(bytesPerSecond)
+	}
+}
+
+// Wait blocks until n bytes can be transferred
+func (l *Limiter) Wait(n int) {
+	if l.Rate() <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+
+	if l.rate <= 0 {
+		l.mu.Unlock()
+		return
+	}
+
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * float64(l.rate)
+	// allow bursts of at most one second
+	if l.tokens > float64(l.rate) {
+		l.tokens = float64(l.rate)
+	}
+	l.last = now
+
+	// the bucket can go in debt: the caller
+	// sleeps until the debt is repaid
+	l.tokens -= float64(n)
+
+	var d time.Duration
+	if l.tokens < 0 {
+		d = time.Duration(-l.tokens / float64(l.rate) * float64(time.Second))
+	}
+
+	l.mu.Unlock()
+
+	time.Sleep(d)
+}
+
+func (l *Limiter) String() string {
+	return fmt.Sprintf("{rate: %d B/s}", l.Rate())
+}
+
+// limited returns the non nil limiters, if any
+func limited(limiters []*Limiter) []*Limiter {
+	var ret []*Limiter
+	for _, l := range limiters {
+		if l != nil {
+			ret = append(ret, l)
+		}
+	}
+	return ret
+}
+
+// unlimited returns true if none of
+// the limiters has a rate. It takes no lock.
+func unlimited(limiters []*Limiter) bool {
+	for _, l := range limiters {
+		if l.Rate() > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// NewReader returns an io.Reader honoring every passed Limiter.
+// The rates are checked on each Read so a limit set during
+// the transfer applies to it; while every rate is 0 the
+// reads pass straight through. If every limiter is nil
+// r is returned unchanged.
+func NewReader(r io.Reader, limiters ...*Limiter) io.Reader {
+	l := limited(limiters)
+	if len(l) == 0 {
+		return r
+	}
+
+	return &reader{r: r, limiters: l}
+}
+
+// NewWriter returns an io.Writer honoring every passed Limiter.
+// The rates are checked on each Write so a limit set during
+// the transfer applies to it; while every rate is 0 the
+// writes pass straight through. The returned writer is an
+// io.ReaderFrom so io.Copy can still use the one of w.
+// If every limiter is nil w is returned unchanged.
+func NewWriter(w io.Writer, limiters ...*Limiter) io.Writer {
+	l := limited(limiters)
+	if len(l) == 0 {
+		return w
+	}
+
+	return &writer{w: w, limiters: l}
+}
+
+type reader struct {
+	r        io.Reader
+	limiters []*Limiter
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if unlimited(r.limiters) {
+		return r.r.Read(p)
+	}
+
+	if len(p) > maxChunk {
+		p = p[:maxChunk]
+	}
+
+	n, err := r.r.Read(p)
+
+	for _, l := range r.limiters {
+		l.Wait(n)
+	}
+
+	return n, err
+}
+
+type writer struct {
+	w        io.Writer
+	limiters []*Limiter
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if unlimited(w.limiters) {
+		return w.w.Write(p)
+	}
+
+	written := 0
+
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > maxChunk {
+			chunk = chunk[:maxChunk]
+		}
+
+		for _, l := range w.limiters {
+			l.Wait(len(chunk))
+		}
+
+		n, err := w.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+
+		p = p[len(chunk):]
+	}
+
+	return written, nil
+}
+
+// ReadFrom copies r in segments: while unlimited each
+// segment goes through io.CopyN, that uses the
+// io.ReaderFrom of the wrapped writer (sendfile
+// for a TCP connection), otherwise through Write.
+func (w *writer) ReadFrom(r io.Reader) (int64, error) {
+	var copied int64
+	buf := make([]byte, maxChunk)
+
+	for {
+		var n int64
+		var err error
+
+		if unlimited(w.limiters) {
+			n, err = io.CopyN(w.w, r, maxSegment)
+			copied += n
+			if err == io.EOF {
+				return copied, nil
+			}
+			if err != nil {
+				return copied, err
+			}
+			continue
+		}
+
+		nr, rerr := r.Read(buf)
+		if nr > 0 {
+			nw, werr := w.Write(buf[:nr])
+			copied += int64(nw)
+			if werr != nil {
+				return copied, werr
+			}
+		}
+		if rerr == io.EOF {
+			return copied, nil
+		}
+		if rerr != nil {
+			return copied, rerr
+		}
+	}
+}
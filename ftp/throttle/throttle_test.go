@@ -0,0 +1,110 @@
+package throttle
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNilIsNotWrapped(t *testing.T) {
+	buf := new(bytes.Buffer)
+
+	assert.Equal(t, buf, NewWriter(buf, nil, nil))
+	assert.Equal(t, buf, NewReader(buf))
+}
+
+// countingWriter counts the Write and ReadFrom calls
+type countingWriter struct {
+	bytes.Buffer
+	writes    int
+	readFroms int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.writes++
+	return c.Buffer.Write(p)
+}
+
+func (c *countingWriter) ReadFrom(r io.Reader) (int64, error) {
+	c.readFroms++
+	return c.Buffer.ReadFrom(r)
+}
+
+func TestUnlimitedIsNotWrapped(t *testing.T) {
+	// unlimited: no chunks, no waits and
+	// io.Copy reaches the io.ReaderFrom of dst
+	dst := new(countingWriter)
+	w := NewWriter(dst, NewLimiter(0), NewLimiter(0))
+
+	start := time.Now()
+	n, err := w.Write(make([]byte, 10*maxChunk))
+	assert.NoError(t, err)
+	assert.Equal(t, 10*maxChunk, n)
+	assert.Equal(t, 1, dst.writes)
+
+	// not an io.WriterTo, so io.Copy calls ReadFrom
+	src := struct{ io.Reader }{bytes.NewReader(make([]byte, 10*maxChunk))}
+	copied, err := io.Copy(w, src)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10*maxChunk), copied)
+	assert.Equal(t, 1, dst.writes)
+	assert.True(t, dst.readFroms > 0)
+
+	b := make([]byte, 10*maxChunk)
+	nr, err := NewReader(bytes.NewReader(b), NewLimiter(0)).Read(b)
+	assert.NoError(t, err)
+	assert.Equal(t, 10*maxChunk, nr)
+	assert.True(t, time.Since(start) < 100*time.Millisecond)
+}
+
+func TestRateSetDuringTransfer(t *testing.T) {
+	// unlimited when the transfer starts
+	l := NewLimiter(0)
+	r := NewReader(bytes.NewReader(make([]byte, 128*1024)), l)
+
+	b := make([]byte, maxChunk)
+	_, err := r.Read(b)
+	assert.NoError(t, err)
+
+	l.SetRate(64 * 1024)
+
+	start := time.Now()
+	_, err = ioutil.ReadAll(r)
+	elapsed := time.Since(start)
+
+	assert.NoError(t, err)
+	assert.True(t, elapsed > 800*time.Millisecond, "elapsed %s", elapsed)
+}
+
+func TestReaderRate(t *testing.T) {
+	// 64KB at 64KB/s: the first second is the burst,
+	// the second one must wait
+	payload := make([]byte, 128*1024)
+
+	start := time.Now()
+	b, err := ioutil.ReadAll(NewReader(bytes.NewReader(payload), NewLimiter(64*1024)))
+	elapsed := time.Since(start)
+
+	assert.NoError(t, err)
+	assert.Equal(t, len(payload), len(b))
+	assert.True(t, elapsed > 800*time.Millisecond, "elapsed %s", elapsed)
+}
+
+func TestWriterSetRate(t *testing.T) {
+	l := NewLimiter(1)
+	l.SetRate(0)
+
+	buf := new(bytes.Buffer)
+	w := &writer{w: buf, limiters: []*Limiter{l}}
+
+	start := time.Now()
+	n, err := w.Write(make([]byte, 100*1024))
+
+	assert.NoError(t, err)
+	assert.Equal(t, 100*1024, n)
+	assert.True(t, time.Since(start) < 100*time.Millisecond)
+}
@@ -2,6 +2,8 @@
 // interface
 package identity
 
+import "net"
+
 // Identity has to be implemented
 // by authenticators
 type Identity interface {
@@ -10,3 +12,43 @@ type Identity interface {
 	Authenticated() bool
 	SetAuthenticated(auth bool)
 }
+
+// BandwidthLimited can be optionally implemented
+// by an Identity to limit the data transfer rate
+// of the user sessions. The limits are in bytes per
+// second, 0 means unlimited.
+type BandwidthLimited interface {
+	DownloadLimit() int64
+	UploadLimit() int64
+}
+
+// IPRestricted can be optionally implemented
+// by an Identity to restrict the remote addresses
+// the user can log in from. AllowedFrom is called
+// after a successful PASS with the control connection
+// remote IP.
+type IPRestricted interface {
+	AllowedFrom(ip net.IP) bool
+}
+
+// Permissions checked by the FTP commands
+// (see Authorizer)
+const (
+	PermissionList   = "list"
+	PermissionRead   = "read"
+	PermissionWrite  = "write"
+	PermissionDelete = "delete"
+	PermissionRename = "rename"
+	PermissionMkdir  = "mkdir"
+	// PermissionChown is never implied: the Authorizer
+	// must grant it explicitly (SITE CHOWN)
+	PermissionChown = "chown"
+)
+
+// Authorizer can be optionally implemented
+// by an Identity to restrict the commands the
+// user can issue. The identities not implementing
+// it have every permission.
+type Authorizer interface {
+	HasPermission(permission string) bool
+}
@@ -6,7 +6,11 @@ package main
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"flag"
+	"io/ioutil"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"strings"
@@ -15,9 +19,18 @@ import (
 
 	log "github.com/sirupsen/logrus"
 	"github.com/mindflavor/ftpserver2/ftp"
+	"github.com/mindflavor/ftpserver2/ftp/actions"
+	"github.com/mindflavor/ftpserver2/ftp/auditlog"
+	"github.com/mindflavor/ftpserver2/ftp/certstore"
 	"github.com/mindflavor/ftpserver2/ftp/fs"
 	"github.com/mindflavor/ftpserver2/ftp/fs/azure"
 	"github.com/mindflavor/ftpserver2/ftp/fs/localFS"
+	"github.com/mindflavor/ftpserver2/ftp/iprules"
+	"github.com/mindflavor/ftpserver2/ftp/logintracker"
+	"github.com/mindflavor/ftpserver2/ftp/metrics"
+	"github.com/mindflavor/ftpserver2/ftp/session"
+	"github.com/mindflavor/ftpserver2/identity"
+	"github.com/mindflavor/ftpserver2/identity/basic"
 
 	"github.com/rifflock/lfshook"
 )
@@ -33,10 +46,14 @@ func main() {
 	logLevel := flag.String("ll", "Info", "Minimum log level. Available values are Debug, Info, Warn, Error")
 	azureAccount := flag.String("an", "", "Azure blob storage account name")
 	azureKey := flag.String("ak", "", "Azure blob storage account key (either primary or secondary)")
+	azureOwner := flag.String("azureOwner", azureFS.DefaultOwner, "Owner shown for the Azure containers and blobs")
+	azureGroup := flag.String("azureGroup", azureFS.DefaultOwner, "Group shown for the Azure containers and blobs")
 	localFSRoot := flag.String("lfs", "", "Local file system root")
+	localFSHashCache := flag.Int("lfsHashCache", 0, "Number of file digests (HASH, XMD5 etc...) to cache for the local file system. 0 disables the cache")
 
-	tlsCertFile := flag.String("crt", "", "TLS certificate file")
-	tlsKeyFile := flag.String("key", "", "TLS certificate key file")
+	tlsCertFile := flag.String("crt", "", "TLS certificate file. Pass a comma separated list to serve several host names (selected by SNI, the first one is the default)")
+	tlsKeyFile := flag.String("key", "", "TLS certificate key file. Pass a comma separated list matching the crt one")
+	certReload := flag.Duration("certReload", time.Minute, "Interval between the checks for renewed certificate files (0 disables the check, SIGHUP reloads them anyway)")
 
 	plainCmdPort := flag.Int("plainPort", 21, "Plain FTP port (unencrypted). If you specify a TLS certificate and key encryption you can pass -1 to start a SFTP implicit server only")
 	encrCmdPort := flag.Int("tlsPort", 990, "Encrypted FTP port. If you do not specify a TLS certificate this port is ignored. If you specify -1 the implicit SFTP is disabled")
@@ -44,6 +61,49 @@ func main() {
 	lowerPort := flag.Int("minPasvPort", 50000, "Lower passive port range")
 	higerPort := flag.Int("maxPasvPort", 50100, "Higher passive port range")
 
+	maxDownloadRate := flag.Int64("maxDownloadRate", 0, "Maximum total download rate in bytes per second (0 for unlimited)")
+	maxUploadRate := flag.Int64("maxUploadRate", 0, "Maximum total upload rate in bytes per second (0 for unlimited)")
+	maxSessionDownloadRate := flag.Int64("maxSessionDownloadRate", 0, "Maximum download rate of each session in bytes per second (0 for unlimited)")
+	maxSessionUploadRate := flag.Int64("maxSessionUploadRate", 0, "Maximum upload rate of each session in bytes per second (0 for unlimited)")
+
+	maxSessions := flag.Int("maxSessions", 0, "Maximum number of concurrent sessions (0 for unlimited)")
+	maxSessionsPerIP := flag.Int("maxSessionsPerIP", 0, "Maximum number of concurrent sessions from the same IP (0 for unlimited)")
+	maxLoginsPerUser := flag.Int("maxLoginsPerUser", 0, "Maximum number of concurrent logins of the same user (0 for unlimited)")
+
+	maxLoginAttempts := flag.Int("maxLoginAttempts", 5, "Failed logins after which the connection is closed (0 for unlimited)")
+	banFailures := flag.Int("banFailures", 0, "Failed logins after which the remote IP or the username is temporarily banned (0 disables bans)")
+	banWindow := flag.Duration("banWindow", 10*time.Minute, "Time window in which the failed logins are counted for the ban")
+	banDuration := flag.Duration("banDuration", 30*time.Minute, "Duration of the bans")
+
+	tlsMinVersion := flag.String("tlsMinVersion", "1.2", "Minimum TLS version. Available values are 1.0, 1.1, 1.2, 1.3")
+	clientCA := flag.String("clientCA", "", "PEM file of the CAs verifying the client certificates (mutual TLS)")
+	clientCertAuth := flag.String("clientCertAuth", "none", "How client certificates are used to log in: none, sufficient (the certificate common name replaces the password) or required (both password and certificate)")
+
+	requireTLS := flag.Bool("requireTLS", false, "Require AUTH TLS before USER and PASS")
+	requireProtP := flag.Bool("requirePROT", false, "Require encrypted data connections (PROT P)")
+	requireTLSResume := flag.Bool("requireTLSResume", false, "Refuse the encrypted data connections that do not resume the TLS session of the control connection")
+	plaintextIPs := flag.String("plaintextIPs", "", "Comma separated list of CIDRs exempted from requireTLS and requirePROT")
+	plaintextUsers := flag.String("plaintextUsers", "", "Comma separated list of users exempted from requireTLS and requirePROT")
+
+	proxyProtocol := flag.String("proxyProtocol", "", "Comma separated list of trusted proxy CIDRs allowed to send PROXY protocol headers. Empty disables the PROXY protocol")
+
+	ipRulesFile := flag.String("ipRules", "", "IP allow/deny rules file (reloaded on SIGHUP)")
+
+	metricsAddr := flag.String("metrics", "", "Address of the Prometheus metrics HTTP endpoint (for example :9100). Empty disables the metrics")
+
+	xferLogFile := flag.String("xferlog", "", "Transfer audit log file. Empty disables the audit log")
+	xferLogFormat := flag.String("xferlogFormat", "xferlog", "Transfer audit log format. Available values are xferlog (wu-ftpd compatible) and json (one object per line)")
+
+	actionsFile := flag.String("actions", "", "Post-upload actions rules file (commands and webhooks selected by path)")
+	uploadCommand := flag.String("uploadCommand", "", "Command run after every upload. The file details are passed in the FTP_* environment variables")
+	uploadWebhook := flag.String("uploadWebhook", "", "URL receiving a JSON POST after every upload")
+	webhookSecret := flag.String("webhookSecret", "", "Key of the HMAC-SHA256 signature of the uploadWebhook requests")
+
+	allowRMDA := flag.Bool("allowRMDA", false, "Allow recursive directory deletion (RMDA command)")
+	listMaxDepth := flag.Int("listMaxDepth", session.DefaultListLimits().MaxDepth, "Subdirectory levels listed by LIST -R and NLST -R (0 for unlimited)")
+	listMaxEntries := flag.Int("listMaxEntries", session.DefaultListLimits().MaxEntries, "Entries after which a directory listing is truncated (0 for unlimited)")
+	maskOwner := flag.Bool("maskOwner", false, "Show the logged in username as owner and group of every file in the directory listings")
+
 	logFileDebug := flag.String("lDebug", "", "Debug level log file")
 	logFileInfo := flag.String("lInfo", "", "Info level log file")
 	logFileWarn := flag.String("lWarn", "", "Warn level log file")
@@ -84,20 +144,36 @@ func main() {
 	var fs fs.FileProvider
 	var err error
 
-	var cert tls.Certificate
+	certs := certstore.New()
 	if *tlsCertFile != "" && *tlsKeyFile != "" {
-		cert, err = tls.LoadX509KeyPair(*tlsCertFile, *tlsKeyFile)
-		if err != nil {
-			panic(err)
+		certFiles := strings.Split(*tlsCertFile, ",")
+		keyFiles := strings.Split(*tlsKeyFile, ",")
+		if len(certFiles) != len(keyFiles) {
+			log.Error("main::main crt and key must list the same number of files")
+			os.Exit(-1)
+		}
+
+		for i := range certFiles {
+			if err := certs.Add(certFiles[i], keyFiles[i]); err != nil {
+				panic(err)
+			}
+		}
+
+		if *certReload > 0 {
+			go certs.Watch(*certReload, nil)
 		}
 	}
 
 	if *azureAccount != "" && *azureKey != "" {
 		log.WithFields(log.Fields{"account": *azureAccount}).Info("main::main initializating Azure blob storage backend")
-		fs, err = azureFS.New(*azureAccount, *azureKey)
+		fs, err = azureFS.NewWithOwner(*azureAccount, *azureKey, *azureOwner, *azureGroup)
 	} else {
 		log.WithFields(log.Fields{"localFSRoot": *localFSRoot}).Info("main::main initializating local fs backend")
-		fs, err = localFS.New(*localFSRoot)
+		if *localFSHashCache > 0 {
+			fs, err = localFS.NewWithHashCache(*localFSRoot, *localFSHashCache)
+		} else {
+			fs, err = localFS.New(*localFSRoot)
+		}
 	}
 
 	if err != nil {
@@ -113,36 +189,215 @@ func main() {
 	var srv *ftp.Server
 	if *tlsCertFile != "" && *tlsKeyFile != "" {
 		if *encrCmdPort == -1 {
-			srv = ftp.NewTLS(*plainCmdPort, &cert, timeout, *lowerPort, *higerPort, authFunc, fs)
+			// the certificates are provided by the TLS configuration below
+			srv = ftp.NewTLS(*plainCmdPort, nil, timeout, *lowerPort, *higerPort, authFunc, fs)
 		} else {
-			srv = ftp.New(*plainCmdPort, *encrCmdPort, &cert, timeout, *lowerPort, *higerPort, authFunc, fs)
+			srv = ftp.New(*plainCmdPort, *encrCmdPort, nil, timeout, *lowerPort, *higerPort, authFunc, fs)
 		}
 	} else {
 		srv = ftp.NewPlain(*plainCmdPort, nil, timeout, *lowerPort, *higerPort, authFunc, fs)
 	}
 
+	if *tlsCertFile != "" && *tlsKeyFile != "" {
+		tlsConfig := &tls.Config{GetCertificate: certs.GetCertificate}
+
+		switch *tlsMinVersion {
+		case "1.0":
+			tlsConfig.MinVersion = tls.VersionTLS10
+		case "1.1":
+			tlsConfig.MinVersion = tls.VersionTLS11
+		case "1.2":
+			tlsConfig.MinVersion = tls.VersionTLS12
+		case "1.3":
+			tlsConfig.MinVersion = tls.VersionTLS13
+		default:
+			log.WithFields(log.Fields{"tlsMinVersion": *tlsMinVersion}).Error("main::main unsupported TLS version")
+			os.Exit(-1)
+		}
+
+		if *clientCA != "" {
+			pem, err := ioutil.ReadFile(*clientCA)
+			if err != nil {
+				panic(err)
+			}
+
+			tlsConfig.ClientCAs = x509.NewCertPool()
+			if !tlsConfig.ClientCAs.AppendCertsFromPEM(pem) {
+				log.WithFields(log.Fields{"clientCA": *clientCA}).Error("main::main no certificates found in the client CA file")
+				os.Exit(-1)
+			}
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+
+		srv.SetTLSConfig(tlsConfig)
+	}
+
+	// the certificate common name must match the username
+	certAuthFunc := func(username string, clientCert *x509.Certificate) identity.Identity {
+		if clientCert.Subject.CommonName != username {
+			return nil
+		}
+		return basicidentity.New(username, false)
+	}
+
+	switch strings.ToLower(*clientCertAuth) {
+	case "none":
+	case "sufficient":
+		srv.SetClientCertAuth(session.ClientCertSufficient, certAuthFunc)
+	case "required":
+		srv.SetClientCertAuth(session.ClientCertRequired, certAuthFunc)
+	default:
+		log.WithFields(log.Fields{"clientCertAuth": *clientCertAuth}).Error("main::main unsupported client certificate authentication mode")
+		os.Exit(-1)
+	}
+
+	if *metricsAddr != "" {
+		m := metrics.New()
+		srv.SetMetrics(m)
+
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", m.Handler())
+		go func() {
+			log.WithFields(log.Fields{"metricsAddr": *metricsAddr}).Info("main::main metrics endpoint started")
+			if err := http.ListenAndServe(*metricsAddr, mux); err != nil {
+				log.WithFields(log.Fields{"metricsAddr": *metricsAddr, "err": err}).Error("main::main metrics endpoint failed")
+			}
+		}()
+	}
+
+	var xferLog *auditlog.Logger
+	if *xferLogFile != "" {
+		format, err := auditlog.ParseFormat(*xferLogFormat)
+		if err != nil {
+			panic(err)
+		}
+
+		xferLog, err = auditlog.Open(*xferLogFile, format)
+		if err != nil {
+			panic(err)
+		}
+
+		srv.SetAuditLog(xferLog)
+	}
+
+	var uploadRules actions.Rules
+	if *actionsFile != "" {
+		rules, err := actions.LoadFile(*actionsFile, *localFSRoot)
+		if err != nil {
+			panic(err)
+		}
+		uploadRules = append(uploadRules, rules...)
+	}
+	if *uploadCommand != "" {
+		toks := strings.Fields(*uploadCommand)
+		uploadRules = append(uploadRules, actions.Rule{Path: "/", Action: &actions.Command{Name: toks[0], Args: toks[1:], Root: *localFSRoot, Timeout: actions.DefaultCommandTimeout}})
+	}
+	if *uploadWebhook != "" {
+		uploadRules = append(uploadRules, actions.Rule{Path: "/", Action: actions.NewWebhook(*uploadWebhook, []byte(*webhookSecret))})
+	}
+	if len(uploadRules) > 0 {
+		srv.OnUploadComplete(session.HookAsync, uploadRules.Handler())
+	}
+
+	srv.SetAllowRecursiveDelete(*allowRMDA)
+	srv.SetMaskOwner(*maskOwner)
+	srv.SetListLimits(session.ListLimits{MaxDepth: *listMaxDepth, MaxEntries: *listMaxEntries})
+	srv.SetBandwidthLimits(*maxDownloadRate, *maxUploadRate)
+	srv.SetSessionBandwidthLimits(*maxSessionDownloadRate, *maxSessionUploadRate)
+	srv.SetMaxSessions(*maxSessions)
+	srv.SetMaxSessionsPerIP(*maxSessionsPerIP)
+	srv.SetMaxLoginsPerUser(*maxLoginsPerUser)
+
+	loginPolicy := logintracker.DefaultPolicy()
+	loginPolicy.MaxAttemptsPerConnection = *maxLoginAttempts
+	loginPolicy.MaxFailures = *banFailures
+	loginPolicy.Window = *banWindow
+	loginPolicy.BanDuration = *banDuration
+	srv.SetLoginPolicy(loginPolicy)
+
+	if *proxyProtocol != "" {
+		srv.SetProxyProtocol(parseCIDRList(*proxyProtocol))
+	}
+
+	tlsPolicy := session.TLSPolicy{
+		RequireControl: *requireTLS,
+		RequireData:    *requireProtP,
+	}
+	if *plaintextIPs != "" {
+		tlsPolicy.PlaintextIPs = parseCIDRList(*plaintextIPs)
+	}
+	if *plaintextUsers != "" {
+		tlsPolicy.PlaintextUsers = strings.Split(*plaintextUsers, ",")
+	}
+	srv.SetTLSPolicy(tlsPolicy)
+	srv.SetRequireTLSResumption(*requireTLSResume)
+
+	loadIPRules := func() error {
+		if *ipRulesFile == "" {
+			return nil
+		}
+
+		cfg, err := iprules.LoadFile(*ipRulesFile)
+		if err != nil {
+			return err
+		}
+
+		srv.SetIPRules(cfg)
+		log.WithFields(log.Fields{"file": *ipRulesFile}).Info("main::main IP rules loaded")
+		return nil
+	}
+	if err := loadIPRules(); err != nil {
+		log.WithFields(log.Fields{"file": *ipRulesFile, "err": err}).Fatal("main::main cannot load IP rules")
+	}
+
 	srv.Accept()
 
 	signal_chan := make(chan os.Signal, 1)
-	var code int
-	signal.Notify(signal_chan)
-	for {
-		s := <-signal_chan
-		switch s {
-		case syscall.SIGINT:
-			log.WithFields(log.Fields{"signal": "SIGINT"}).Warn("main::main " + s.String())
-			code = 0
-		case syscall.SIGTERM:
-			log.WithFields(log.Fields{"signal": "SIGTERM"}).Warn("main::main " + s.String())
-			code = 0
-		case syscall.SIGPIPE:
-			log.WithFields(log.Fields{"signal": "SIGPIPE"}).Warn("main::main " + s.String())
+	signal.Notify(signal_chan, handledSignals...)
+	waitForShutdown(signal_chan, func() {
+		if err := loadIPRules(); err != nil {
+			log.WithFields(log.Fields{"file": *ipRulesFile, "err": err}).Error("main::main cannot load IP rules, keeping the previous ones")
+		}
+		if certs.Len() > 0 {
+			certs.Reload()
+		}
+	})
+
+	// closed here: os.Exit does not run the deferred calls
+	if err := xferLog.Close(); err != nil {
+		log.WithFields(log.Fields{"file": *xferLogFile, "err": err}).Error("main::main cannot close the audit log")
+	}
+	os.Exit(0)
+}
+
+// handledSignals are the signals main listens to. Any other
+// signal (for example the SIGCHLD sent when a command
+// action exits) keeps its default behaviour.
+var handledSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP}
+
+// waitForShutdown calls reload on SIGHUP and
+// returns on SIGINT or SIGTERM
+func waitForShutdown(signals <-chan os.Signal, reload func()) {
+	for s := range signals {
+		log.WithFields(log.Fields{"signal": s.String()}).Warn("main::main " + s.String())
+		if s == syscall.SIGHUP {
+			reload()
 			continue
-		default:
-			log.Error("main::main Unknown signal (" + s.String() + ")")
-			code = 1
 		}
-		break
+		return
+	}
+}
+
+// parseCIDRList parses a comma separated list of CIDRs.
+// It panics on invalid entries.
+func parseCIDRList(list string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, s := range strings.Split(list, ",") {
+		n, err := iprules.ParseCIDR(strings.TrimSpace(s))
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
 	}
-	os.Exit(code)
+	return nets
 }
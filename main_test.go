@@ -1,10 +1,15 @@
 package main
 
 import (
+	"os"
+	"os/signal"
+	"syscall"
 	"testing"
 	"time"
 
 	"github.com/mindflavor/ftpserver2/ftp"
+	"github.com/mindflavor/ftpserver2/ftp/actions"
+	"github.com/mindflavor/ftpserver2/ftp/session"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -18,3 +23,36 @@ func TestFTPBasic(t *testing.T) {
 
 	assert.NotNil(t, ftp)
 }
+
+func TestCommandActionDoesNotStopServer(t *testing.T) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, handledSignals...)
+	defer signal.Stop(signals)
+
+	reloaded := make(chan struct{}, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		waitForShutdown(signals, func() { reloaded <- struct{}{} })
+	}()
+
+	// the command exit sends SIGCHLD to the server
+	cmd := &actions.Command{Name: "/bin/sh", Args: []string{"-c", "true"}}
+	assert.NoError(t, cmd.Run(session.Event{Type: session.EventUploadComplete, Path: "/in/file.txt"}))
+
+	select {
+	case <-done:
+		t.Fatal("the command action stopped the server")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	signals <- syscall.SIGHUP
+	<-reloaded
+
+	signals <- syscall.SIGTERM
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("SIGTERM did not stop the server")
+	}
+}